@@ -1,29 +1,38 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type archivesspaceReview struct {
-	ID              int64        `json:"id"`
-	UnitID          int64        `json:"unitID"`
-	MetadataID      int64        `gorm:"column:metadata_id" json:"metadataID"`
-	Metadata        *metadata    `gorm:"foreignKey:MetadataID" json:"metadata,omitempty"`
-	SubmitStaffID   int64        `gorm:"column:submit_staff_id" json:"-"`
-	Submitter       staffMember  `gorm:"foreignKey:SubmitStaffID" json:"submitter"`
-	SubmittedAt     time.Time    `json:"submittedAt"`
-	ReviewStaffID   *int64       `gorm:"column:review_staff_id" json:"-"`
-	Reviewer        *staffMember `gorm:"foreignKey:ReviewStaffID" json:"reviewer,omitempty"`
-	ReviewStartedAt *time.Time   `json:"reviewStartedAt,omitempty"`
-	Status          string       `json:"status"`
-	Notes           string       `json:"notes"`
-	PublishedAt     *time.Time   `json:"publishedAt,omitempty"`
+	ID                 int64           `json:"id"`
+	UnitID             *int64          `json:"unitID"`
+	PublishUnitStaffID *int64          `json:"-"`
+	PublishUnitStaff   *staffMember    `gorm:"foreignKey:PublishUnitStaffID" json:"publishUnitStaff,omitempty"`
+	MetadataID         int64           `gorm:"column:metadata_id" json:"metadataID"`
+	Metadata           *metadata       `gorm:"foreignKey:MetadataID" json:"metadata,omitempty"`
+	SubmitStaffID      int64           `gorm:"column:submit_staff_id" json:"-"`
+	Submitter          staffMember     `gorm:"foreignKey:SubmitStaffID" json:"submitter"`
+	SubmittedAt        time.Time       `json:"submittedAt"`
+	ReviewStaffID      *int64          `gorm:"column:review_staff_id" json:"-"`
+	Reviewer           *staffMember    `gorm:"foreignKey:ReviewStaffID" json:"reviewer,omitempty"`
+	AssignedAt         *time.Time      `json:"assignedAt,omitempty"`
+	ReviewStartedAt    *time.Time      `json:"reviewStartedAt,omitempty"`
+	Status             string          `json:"status"`
+	Notes              string          `json:"notes"`
+	PublishedAt        *time.Time      `json:"publishedAt,omitempty"`
+	Comments           []reviewComment `gorm:"foreignKey:ArchivesspaceReviewID" json:"comments,omitempty"`
+	Actions            []string        `gorm:"-" json:"actions"`
 }
 
 type asReviewsResponse struct {
@@ -32,9 +41,17 @@ type asReviewsResponse struct {
 	Reviews       []archivesspaceReview `json:"submissions"`
 }
 
+// errors returned by getASPublishUnitID when a supervisor must select the publish unit
+var (
+	errASMultipleUnits  = errors.New("multiple candidate units found")
+	errASNoSuitableUnit = errors.New("no suitable units found")
+)
+
+// errASUnitMismatch is returned by getASJobsPublishUnitID when the selected unit is not the one the jobs service publishes
+var errASUnitMismatch = errors.New("selected unit is not the unit the jobs service will publish")
+
 type asRequest struct {
-	StaffID int64 `json:"user"`
-	Review  bool  `json:"review"`
+	Review bool `json:"review"`
 }
 
 func (svc *serviceContext) beginArchivesSpaceReview(c *gin.Context) {
@@ -47,10 +64,11 @@ func (svc *serviceContext) beginArchivesSpaceReview(c *gin.Context) {
 		return
 	}
 
+	claims := getClaims(c)
 	var staff staffMember
-	err = svc.DB.Find(&staff, req.StaffID).Error
+	err = svc.DB.Find(&staff, claims.UserID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to load as review user %d: %s", req.StaffID, err.Error())
+		log.Printf("ERROR: unable to load as review user %d: %s", claims.UserID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -65,6 +83,15 @@ func (svc *serviceContext) beginArchivesSpaceReview(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	// admins and supervisors may reassign a submission from another reviewer to themselves
+	if err := asReview.checkAction("review", int64(staff.ID)); err != nil {
+		if errors.Is(err, errASAssignedReviewer) == false || (claims.Role != "admin" && claims.Role != "supervisor") {
+			log.Printf("INFO: user %s cannot review metadata %d: %s", staff.ComputingID, mdID, err.Error())
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("INFO: %s %s reassigns review of metadata %d from reviewer %d", claims.Role, staff.ComputingID, mdID, *asReview.ReviewStaffID)
+	}
 
 	if asReview.ReviewStartedAt == nil {
 		now := time.Now()
@@ -81,6 +108,7 @@ func (svc *serviceContext) beginArchivesSpaceReview(c *gin.Context) {
 	}
 
 	asReview.Reviewer = &staff
+	asReview.setActions(int64(staff.ID))
 	c.JSON(http.StatusOK, asReview)
 }
 
@@ -94,30 +122,53 @@ func (svc *serviceContext) publishArchivesSpace(c *gin.Context) {
 		return
 	}
 
+	claims := getClaims(c)
 	var staff staffMember
-	err = svc.DB.Find(&staff, req.StaffID).Error
+	err = svc.DB.Find(&staff, claims.UserID).Error
 	if err != nil {
-		log.Printf("ERROR: unable to load as review user %d: %s", req.StaffID, err.Error())
+		log.Printf("ERROR: unable to load as review user %d: %s", claims.UserID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 	log.Printf("INFO: user %s requests archivesspace publish for metadata %d with review=%t", staff.ComputingID, mdID, req.Review)
 
-	_, err = svc.getASPublishUnitID(mdID)
+	// items in the review workflow can only be published by their reviewer
+	var asReview archivesspaceReview
+	err = svc.DB.Where("metadata_id=?", mdID).Limit(1).Find(&asReview).Error
 	if err != nil {
-		log.Printf("ERROR: get as publish unit for metadata %d failed: %s", mdID, err.Error())
-		c.String(http.StatusBadRequest, err.Error())
+		log.Printf("ERROR: user %s is unable to get as review record for metadata %d: %s", staff.ComputingID, mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if req.Review {
+		if asReview.ID == 0 {
+			c.String(http.StatusBadRequest, fmt.Sprintf("metadata %d has not been submitted for review", mdID))
+			return
+		}
+		if err := asReview.checkAction("publish", int64(staff.ID)); err != nil {
+			log.Printf("INFO: user %s cannot publish metadata %d: %s", staff.ComputingID, mdID, err.Error())
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+	} else if asReview.ID > 0 && asReview.Status != asPublished {
+		log.Printf("INFO: user %s cannot publish metadata %d directly; it is in %s review status", staff.ComputingID, mdID, asReview.Status)
+		c.String(http.StatusConflict, fmt.Sprintf("metadata %d is %s in archivesspace review and must be published by its reviewer", mdID, asReview.Status))
 		return
 	}
 
-	pubPayload := struct {
-		UserID     int64 `json:"userID"`
-		MetadataID int64 `json:"metadataID"`
-	}{
-		UserID:     int64(staff.ID),
-		MetadataID: mdID,
+	_, err = svc.getASJobsPublishUnitID(mdID)
+	if err != nil {
+		log.Printf("ERROR: get as publish unit for metadata %d failed: %s", mdID, err.Error())
+		if errors.Is(err, errASUnitMismatch) {
+			c.String(http.StatusConflict, err.Error())
+		} else {
+			c.String(http.StatusBadRequest, err.Error())
+		}
+		return
 	}
 
+	pubPayload := asPublishPayload{UserID: int64(staff.ID), MetadataID: mdID}
+
 	url := fmt.Sprintf("%s/archivesspace/publish", svc.ExternalSystems.Jobs)
 	if asErr := svc.protectedPost(url, getJWT(c), pubPayload); asErr != nil {
 		log.Printf("ERROR: unable to publish metadata %d: %d %s", mdID, asErr.StatusCode, asErr.Message)
@@ -126,18 +177,12 @@ func (svc *serviceContext) publishArchivesSpace(c *gin.Context) {
 	}
 
 	if req.Review {
-		var asReview archivesspaceReview
-		err = svc.DB.Joins("Metadata").Where("metadata_id=?", mdID).First(&asReview).Error
+		now := time.Now()
+		asReview.Status = asPublished
+		asReview.PublishedAt = &now
+		err = svc.DB.Model(&asReview).Select("Status", "PublishedAt").Updates(&asReview).Error
 		if err != nil {
-			log.Printf("ERROR: user %s is unable to get as review record for metadata %d: %s", staff.ComputingID, mdID, err.Error())
-		} else {
-			now := time.Now()
-			asReview.Status = "published"
-			asReview.PublishedAt = &now
-			err = svc.DB.Save(&asReview).Error
-			if err != nil {
-				log.Printf("ERROR: unable to update metadata published status for metadata %d: %s", mdID, err.Error())
-			}
+			log.Printf("ERROR: unable to update metadata published status for metadata %d: %s", mdID, err.Error())
 		}
 	}
 
@@ -151,6 +196,17 @@ func (svc *serviceContext) resubmitArchivesSpaceReview(c *gin.Context) {
 		c.String(http.StatusBadRequest, "invalid id")
 		return
 	}
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength > 0 {
+		err := c.BindJSON(&req)
+		if err != nil {
+			log.Printf("ERROR: invalid archivesspace resubmit request: %s", err.Error())
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	log.Printf("INFO: resubmit metadata %d for archivesspace review", mdID)
 	var asR archivesspaceReview
 	err := svc.DB.Joins("Metadata").Where("metadata_id=?", mdID).First(&asR).Error
@@ -159,13 +215,23 @@ func (svc *serviceContext) resubmitArchivesSpaceReview(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	asR.Status = "requested"
+	claims := getClaims(c)
+	if err := asR.checkAction("resubmit", int64(claims.UserID)); err != nil {
+		log.Printf("INFO: user %s cannot resubmit metadata %d: %s", claims.ComputeID, mdID, err.Error())
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	asR.Status = asRequested
 	err = svc.DB.Save(&asR).Error
 	if err != nil {
 		log.Printf("ERROR: unable resubmit metadata %d for archivespace review: %s", mdID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	_, err = svc.addReviewComment(&asR, int64(claims.UserID), "resubmitted", strings.TrimSpace(req.Comment))
+	if err != nil {
+		log.Printf("ERROR: unable to add resubmit comment for metadata %d: %s", mdID, err.Error())
+	}
 	c.String(http.StatusOK, "ok")
 }
 
@@ -174,8 +240,7 @@ func (svc *serviceContext) rejectArchivesSpaceSubmission(c *gin.Context) {
 	log.Printf("INFO: received archivesspace reject request for metadata %s", mdID)
 
 	var req struct {
-		UserID int64  `json:"userID"`
-		Notes  string `json:"notes"`
+		Notes string `json:"notes"`
 	}
 	err := c.BindJSON(&req)
 	if err != nil {
@@ -184,7 +249,9 @@ func (svc *serviceContext) rejectArchivesSpaceSubmission(c *gin.Context) {
 		return
 	}
 
-	log.Printf("INFO: user %d rejects archivesspace submission %s with notes [%s]", req.UserID, mdID, req.Notes)
+	claims := getClaims(c)
+	userID := int64(claims.UserID)
+	log.Printf("INFO: user %s rejects archivesspace submission %s with notes [%s]", claims.ComputeID, mdID, req.Notes)
 	var asR archivesspaceReview
 	err = svc.DB.Joins("Metadata").Where("metadata_id=?", mdID).First(&asR).Error
 	if err != nil {
@@ -192,8 +259,13 @@ func (svc *serviceContext) rejectArchivesSpaceSubmission(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if err := asR.checkAction("reject", userID); err != nil {
+		log.Printf("INFO: user %s cannot reject metadata %s: %s", claims.ComputeID, mdID, err.Error())
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
 
-	asR.Status = "rejected"
+	asR.Status = asRejected
 	asR.Notes = req.Notes
 	err = svc.DB.Save(&asR).Error
 	if err != nil {
@@ -201,22 +273,22 @@ func (svc *serviceContext) rejectArchivesSpaceSubmission(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	_, err = svc.addReviewComment(&asR, userID, "rejected", req.Notes)
+	if err != nil {
+		log.Printf("ERROR: unable to add reject comment for metadata %s: %s", mdID, err.Error())
+	}
+	asR.setActions(userID)
 	c.JSON(http.StatusOK, asR)
 }
 
 func (svc *serviceContext) requestArchivesSpaceReview(c *gin.Context) {
-	userID, _ := strconv.ParseInt(c.Query("user"), 10, 64)
+	userID := int64(getClaims(c).UserID)
 	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	if mdID == 0 {
 		log.Printf("ERROR: invalid metadata id %s in archivesspace review request", c.Param("id"))
 		c.String(http.StatusBadRequest, "invalid id")
 		return
 	}
-	if userID == 0 {
-		log.Printf("ERROR: invalid user id %s in archivesspace review request", c.Param("user"))
-		c.String(http.StatusBadRequest, "invalid id")
-		return
-	}
 	log.Printf("INFO: user %d requests archivesspace review for metadata %d", userID, mdID)
 
 	var submitter staffMember
@@ -234,14 +306,18 @@ func (svc *serviceContext) requestArchivesSpaceReview(c *gin.Context) {
 		return
 	}
 
+	// when the unit is ambiguous the review is still created; a supervisor selects the unit before publication
+	asReview := archivesspaceReview{SubmitStaffID: userID, MetadataID: mdID, SubmittedAt: time.Now(), Status: asRequested}
 	tgtUnitID, err := svc.getASPublishUnitID(mdRec.ID)
-	if err != nil {
+	if err == nil {
+		asReview.UnitID = &tgtUnitID
+	} else if errors.Is(err, errASMultipleUnits) || errors.Is(err, errASNoSuitableUnit) {
+		log.Printf("INFO: publish unit for metadata %d must be selected: %s", mdID, err.Error())
+	} else {
 		log.Printf("ERROR: get as publish unit for metadata %d failed: %s", mdID, err.Error())
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-
-	asReview := archivesspaceReview{SubmitStaffID: userID, MetadataID: mdID, UnitID: tgtUnitID, SubmittedAt: time.Now(), Status: "requested"}
 	err = svc.DB.Create(&asReview).Error
 	if err != nil {
 		log.Printf("ERROR: user %d unable to request archives spaces review for %d: %s", userID, mdID, err.Error())
@@ -250,10 +326,79 @@ func (svc *serviceContext) requestArchivesSpaceReview(c *gin.Context) {
 	}
 	asReview.Submitter = submitter
 
+	if c.Query("assign") == "true" {
+		_, err = svc.assignArchivesSpaceReviewer(&asReview)
+		if err != nil {
+			log.Printf("ERROR: unable to assign reviewer for metadata %d: %s", mdID, err.Error())
+		}
+	}
+
+	asReview.setActions(userID)
 	c.JSON(http.StatusOK, asReview)
 }
 
+func (svc *serviceContext) getArchivesSpaceMetadata(md *metadata) (*asMetadata, error) {
+	if md.ExternalURI == nil {
+		return nil, fmt.Errorf("metadata %d does not have an external uri", md.ID)
+	}
+	raw, getErr := svc.getRequest(fmt.Sprintf("%s/archivesspace/lookup?pid=%s&uri=%s", svc.ExternalSystems.Jobs, md.PID, *md.ExternalURI))
+	if getErr != nil {
+		return nil, fmt.Errorf("%d:%s", getErr.StatusCode, getErr.Message)
+	}
+	var asData asMetadata
+	err := json.Unmarshal(raw, &asData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse AS response: %s", err.Error())
+	}
+	return &asData, nil
+}
+
+// getASPublishUnitID returns the unit whose master files are published for an archivesspace record. A unit selected
+// by a supervisor on the review (unit_id with publish_unit_staff_id set) takes precedence over the automatic choice.
 func (svc *serviceContext) getASPublishUnitID(mdID int64) (int64, error) {
+	selectedID, err := svc.getASSelectedUnitID(mdID)
+	if err != nil || selectedID > 0 {
+		return selectedID, err
+	}
+	return svc.getASAutoPublishUnitID(mdID)
+}
+
+// getASJobsPublishUnitID returns the unit the jobs service will publish for an archivesspace record. The jobs service
+// picks the unit itself, so publication is blocked when a supervisor selected a different unit than that choice.
+func (svc *serviceContext) getASJobsPublishUnitID(mdID int64) (int64, error) {
+	selectedID, err := svc.getASSelectedUnitID(mdID)
+	if err != nil {
+		return 0, err
+	}
+	autoID, err := svc.getASAutoPublishUnitID(mdID)
+	if selectedID > 0 && (err != nil || autoID != selectedID) {
+		return 0, fmt.Errorf("%w: unit %d was selected for metadata %d", errASUnitMismatch, selectedID, mdID)
+	}
+	return autoID, err
+}
+
+// getASSelectedUnitID returns the unit a supervisor selected for an archivesspace record, or 0 if there is none
+func (svc *serviceContext) getASSelectedUnitID(mdID int64) (int64, error) {
+	var selected archivesspaceReview
+	err := svc.DB.Select("id", "unit_id").Where("metadata_id=? and unit_id is not null and publish_unit_staff_id is not null", mdID).
+		Limit(1).Find(&selected).Error
+	if err != nil {
+		return 0, fmt.Errorf("find selected unit for metadata %d failed: %s", mdID, err.Error())
+	}
+	if selected.UnitID == nil {
+		return 0, nil
+	}
+	var mfCnt int64
+	svc.DB.Table("master_files").Where("unit_id=? and metadata_id=?", *selected.UnitID, mdID).Count(&mfCnt)
+	if mfCnt == 0 {
+		return 0, fmt.Errorf("selected unit %d has no master files for metadata %d", *selected.UnitID, mdID)
+	}
+	log.Printf("INFO: unit %d is selected for archivesspace metadata %d", *selected.UnitID, mdID)
+	return *selected.UnitID, nil
+}
+
+// getASAutoPublishUnitID picks the unit to publish from the master files of a record, as the jobs service does
+func (svc *serviceContext) getASAutoPublishUnitID(mdID int64) (int64, error) {
 	var tgtUnits []unit
 	var tgtUnitID int64
 	log.Printf("INFO: find unit for archivesspace metadata %d", mdID)
@@ -285,10 +430,10 @@ func (svc *serviceContext) getASPublishUnitID(mdID int64) (int64, error) {
 			}
 		}
 		if candidateCnt == 0 {
-			return 0, fmt.Errorf("no suitable units found")
+			return 0, errASNoSuitableUnit
 		}
 		if candidateCnt > 1 {
-			return 0, fmt.Errorf("multiple candidate units found")
+			return 0, errASMultipleUnits
 		}
 	} else {
 		// If there is only 1 unit present assume this is known to be a good candidate
@@ -310,7 +455,18 @@ func (svc *serviceContext) cancelArchivesSpaceSubmission(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = svc.DB.Delete(&asR).Error
+	claims := getClaims(c)
+	if err := asR.checkAction("cancel", int64(claims.UserID)); err != nil {
+		log.Printf("INFO: user %s cannot cancel archivesspace submission for metadata %s: %s", claims.ComputeID, mdID, err.Error())
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("archivesspace_review_id=?", asR.ID).Delete(&reviewComment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&asR).Error
+	})
 	if err != nil {
 		log.Printf("ERROR: unable to cancel archivesspace submission for metadata %s: %s", mdID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
@@ -348,25 +504,81 @@ func (svc *serviceContext) updateArchivesSpaceSubmissionNotes(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	asR.setActions(int64(getClaims(c).UserID))
 	c.JSON(http.StatusOK, asR)
 }
 
+// getArchivesSpaceReviews returns a page of reviews. By default all unpublished reviews are returned in submission
+// order; start and limit select a page. Filters: status (comma separated), submitter and reviewer (staff ids), and age (minimum days since
+// submission). Sort by submitted, status, pid, title, submitter, reviewer or reviewStarted, in asc or desc order.
+// Total is the number of reviews that match the filters.
 func (svc *serviceContext) getArchivesSpaceReviews(c *gin.Context) {
+	startIndex, _ := strconv.Atoi(c.Query("start"))
+	pageSize, _ := strconv.Atoi(c.Query("limit"))
+	sortBy := c.Query("by")
+	if sortBy == "" {
+		sortBy = "submitted"
+	}
+	sortOrder := c.Query("order")
+	if sortOrder == "" {
+		sortOrder = "asc"
+	}
+	sortFields := map[string]string{
+		"submitted":     "archivesspace_reviews.submitted_at",
+		"status":        "archivesspace_reviews.status",
+		"pid":           "Metadata.pid",
+		"title":         "Metadata.title",
+		"submitter":     "Submitter.last_name",
+		"reviewer":      "Reviewer.last_name",
+		"reviewStarted": "archivesspace_reviews.review_started_at",
+	}
+	sortField, validSort := sortFields[sortBy]
+	if validSort == false || (sortOrder != "asc" && sortOrder != "desc") {
+		log.Printf("ERROR: invalid archivesspace review sort %s %s", sortBy, sortOrder)
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid sort %s %s", sortBy, sortOrder))
+		return
+	}
+
+	filterQ := svc.DB.Model(&archivesspaceReview{}).Joins("Submitter").Joins("Reviewer").Joins("Metadata")
+	if c.Query("status") != "" {
+		filterQ = filterQ.Where("archivesspace_reviews.status in ?", strings.Split(c.Query("status"), ","))
+	} else {
+		filterQ = filterQ.Where("archivesspace_reviews.published_at is null")
+	}
+	if submitterID, _ := strconv.ParseInt(c.Query("submitter"), 10, 64); submitterID > 0 {
+		filterQ = filterQ.Where("archivesspace_reviews.submit_staff_id=?", submitterID)
+	}
+	if reviewerID, _ := strconv.ParseInt(c.Query("reviewer"), 10, 64); reviewerID > 0 {
+		filterQ = filterQ.Where("archivesspace_reviews.review_staff_id=?", reviewerID)
+	}
+	if ageDays, _ := strconv.Atoi(c.Query("age")); ageDays > 0 {
+		filterQ = filterQ.Where("archivesspace_reviews.submitted_at <= ?", time.Now().AddDate(0, 0, -ageDays))
+	}
+	filterQ = filterQ.Session(&gorm.Session{})
+
 	resp := asReviewsResponse{ViewerBaseURL: fmt.Sprintf("%s/view", svc.ExternalSystems.Curio)}
-	countQ := "select count(id) as total from archivesspace_reviews"
-	err := svc.DB.Raw(countQ).Scan(&resp.Total).Error
+	err := filterQ.Count(&resp.Total).Error
 	if err != nil {
 		log.Printf("ERROR: unable to get archivesspace reviews count: %s", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	err = svc.DB.Joins("Submitter").Joins("Reviewer").Joins("Metadata").Where("published_at is null").Order("submitted_at asc").Find(&resp.Reviews).Error
+	log.Printf("INFO: get archivesspace reviews from %d limit %d order %s %s", startIndex, pageSize, sortField, sortOrder)
+	pageQ := filterQ.Order(fmt.Sprintf("%s %s, archivesspace_reviews.id %s", sortField, sortOrder, sortOrder))
+	if pageSize > 0 {
+		pageQ = pageQ.Offset(startIndex).Limit(pageSize)
+	}
+	err = pageQ.Find(&resp.Reviews).Error
 	if err != nil {
 		log.Printf("ERROR: unable to get archivesspace reviews: %s", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	claims := getClaims(c)
+	for idx := range resp.Reviews {
+		resp.Reviews[idx].setActions(int64(claims.UserID))
+	}
 
 	c.JSON(http.StatusOK, resp)
 }
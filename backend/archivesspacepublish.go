@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type asPublishBatch struct {
+	ID            int64           `json:"id"`
+	StaffMemberID *int64          `json:"-"`
+	StaffMember   *staffMember    `gorm:"foreignKey:StaffMemberID" json:"staffMember,omitempty"`
+	Status        string          `json:"status"`
+	Total         int             `json:"total"`
+	Published     int             `json:"published"`
+	Failed        int             `json:"failed"`
+	Skipped       int             `json:"skipped"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	FinishedAt    *time.Time      `json:"finishedAt,omitempty"`
+	Items         []asPublishItem `gorm:"foreignKey:BatchID" json:"items,omitempty"`
+}
+
+func (asPublishBatch) TableName() string {
+	return "archivesspace_publish_batches"
+}
+
+type asPublishItem struct {
+	ID         int64     `json:"id"`
+	BatchID    int64     `json:"batchID"`
+	MetadataID int64     `json:"metadataID"`
+	Metadata   *metadata `gorm:"foreignKey:MetadataID" json:"metadata,omitempty"`
+	UnitID     *int64    `json:"unitID,omitempty"`
+	Status     string    `json:"status"`
+	Message    string    `json:"message"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func (asPublishItem) TableName() string {
+	return "archivesspace_publish_items"
+}
+
+// asPublishPayload is the request sent to the jobs service to publish a record. The jobs service picks the unit
+// whose master files are published; see getASJobsPublishUnitID.
+type asPublishPayload struct {
+	UserID     int64 `json:"userID"`
+	MetadataID int64 `json:"metadataID"`
+}
+
+type asPublishUnitCandidate struct {
+	UnitID          int64  `json:"unitID"`
+	OrderID         int64  `json:"orderID"`
+	IntendedUseID   int64  `json:"intendedUseID"`
+	IntendedUse     string `json:"intendedUse"`
+	MasterFileCount int64  `json:"masterFileCount"`
+	Selected        bool   `json:"selected"`
+}
+
+type asBulkPublishRequest struct {
+	MetadataIDs  []int64 `json:"metadataIDs"`
+	IngestedFrom string  `json:"ingestedFrom"`
+	IngestedTo   string  `json:"ingestedTo"`
+	Concurrency  int     `json:"concurrency"`
+}
+
+// publishArchivesSpaceBulk publishes many ArchivesSpace records, selected by metadata id or by a DL ingest date
+// range (yyyy-mm-dd, inclusive). Each record is checked for a publish unit and for an open review before it is
+// submitted; records that fail the checks are skipped. Submissions run in the background, at most concurrency
+// (default 4, max 8) at a time, and the per-record results are saved in a batch that can be polled.
+func (svc *serviceContext) publishArchivesSpaceBulk(c *gin.Context) {
+	var req asBulkPublishRequest
+	err := c.BindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid bulk archivesspace publish request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Concurrency <= 0 {
+		req.Concurrency = 4
+	}
+	if req.Concurrency > 8 {
+		req.Concurrency = 8
+	}
+
+	mdQ := svc.DB.Select("id", "pid").Where("type=? and external_system_id=?", "ExternalMetadata", 1)
+	if len(req.MetadataIDs) > 0 {
+		mdQ = mdQ.Where("id in ?", req.MetadataIDs)
+	} else if req.IngestedFrom != "" || req.IngestedTo != "" {
+		if req.IngestedFrom != "" {
+			fromDate, err := parseDateString(req.IngestedFrom)
+			if err != nil {
+				c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid date", req.IngestedFrom))
+				return
+			}
+			mdQ = mdQ.Where("date_dl_ingest >= ?", fromDate)
+		}
+		if req.IngestedTo != "" {
+			toDate, err := parseDateString(req.IngestedTo)
+			if err != nil {
+				c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid date", req.IngestedTo))
+				return
+			}
+			mdQ = mdQ.Where("date_dl_ingest < ?", toDate.AddDate(0, 0, 1))
+		}
+	} else {
+		c.String(http.StatusBadRequest, "metadataIDs or an ingest date range is required")
+		return
+	}
+
+	var records []metadata
+	err = mdQ.Order("id asc").Find(&records).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace records for bulk publish: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(records) == 0 {
+		c.String(http.StatusNotFound, "no archivesspace records match the request")
+		return
+	}
+	if len(req.MetadataIDs) > len(records) {
+		log.Printf("INFO: %d of the requested metadata ids are not archivesspace records", len(req.MetadataIDs)-len(records))
+	}
+
+	var openReviews []int64
+	err = svc.DB.Table("archivesspace_reviews").Where("status<>?", asPublished).Pluck("metadata_id", &openReviews).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get open archivesspace reviews: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	inReview := make(map[int64]bool)
+	for _, mdID := range openReviews {
+		inReview[mdID] = true
+	}
+
+	claims := getClaims(c)
+	staffID := int64(claims.UserID)
+	batch := asPublishBatch{StaffMemberID: &staffID, Status: "running", Total: len(records), CreatedAt: time.Now()}
+	now := time.Now()
+	for _, md := range records {
+		item := asPublishItem{MetadataID: md.ID, Status: "pending", UpdatedAt: now}
+		if inReview[md.ID] {
+			item.Status = "skipped"
+			item.Message = "record is in archivesspace review"
+		} else if unitID, err := svc.getASJobsPublishUnitID(md.ID); err != nil {
+			item.Status = "skipped"
+			item.Message = err.Error()
+		} else {
+			item.UnitID = &unitID
+		}
+		if item.Status == "skipped" {
+			batch.Skipped++
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	if batch.Skipped == batch.Total {
+		batch.Status = "finished"
+		batch.FinishedAt = &now
+	}
+
+	err = svc.DB.Create(&batch).Error
+	if err != nil {
+		log.Printf("ERROR: unable to create archivesspace publish batch: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: user %s started archivesspace publish batch %d with %d records; %d skipped", claims.ComputeID, batch.ID, batch.Total, batch.Skipped)
+
+	if batch.Status == "running" {
+		go svc.runArchivesSpacePublishBatch(&batch, req.Concurrency)
+	}
+	c.JSON(http.StatusOK, gin.H{"batchID": batch.ID, "total": batch.Total, "skipped": batch.Skipped})
+}
+
+// runArchivesSpacePublishBatch submits the pending items of a batch to the jobs service
+func (svc *serviceContext) runArchivesSpacePublishBatch(batch *asPublishBatch, concurrency int) {
+	url := fmt.Sprintf("%s/archivesspace/publish", svc.ExternalSystems.Jobs)
+	sem := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	for idx := range batch.Items {
+		item := &batch.Items[idx]
+		if item.Status != "pending" {
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			// tokens are short lived, so each submission gets a fresh one
+			item.Status = "published"
+			jwt, err := svc.mintTemporaryJWT()
+			if err != nil {
+				item.Status = "failed"
+				item.Message = err.Error()
+			} else {
+				payload := asPublishPayload{UserID: *batch.StaffMemberID, MetadataID: item.MetadataID}
+				if asErr := svc.protectedPost(url, jwt, payload); asErr != nil {
+					log.Printf("ERROR: batch %d unable to publish metadata %d: %d %s", batch.ID, item.MetadataID, asErr.StatusCode, asErr.Message)
+					item.Status = "failed"
+					item.Message = fmt.Sprintf("%d: %s", asErr.StatusCode, asErr.Message)
+				}
+			}
+
+			item.UpdatedAt = time.Now()
+			counter := "published"
+			if item.Status == "failed" {
+				counter = "failed"
+			}
+			err = svc.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(item).Select("Status", "Message", "UpdatedAt").Updates(item).Error; err != nil {
+					return err
+				}
+				return tx.Model(&asPublishBatch{}).Where("id=?", batch.ID).Update(counter, gorm.Expr(fmt.Sprintf("%s + 1", counter))).Error
+			})
+			if err != nil {
+				log.Printf("ERROR: unable to save batch %d result for metadata %d: %s", batch.ID, item.MetadataID, err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	err := svc.DB.Model(batch).Updates(map[string]any{"status": "finished", "finished_at": now}).Error
+	if err != nil {
+		log.Printf("ERROR: unable to finish archivesspace publish batch %d: %s", batch.ID, err.Error())
+	}
+	log.Printf("INFO: archivesspace publish batch %d finished", batch.ID)
+}
+
+// getArchivesSpacePublishBatches lists the most recent publish batches without their items
+func (svc *serviceContext) getArchivesSpacePublishBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	var batches []asPublishBatch
+	err := svc.DB.Preload("StaffMember").Order("id desc").Limit(limit).Find(&batches).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace publish batches: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batches)
+}
+
+// getArchivesSpacePublishBatch returns the status of a publish batch and the result for each record
+func (svc *serviceContext) getArchivesSpacePublishBatch(c *gin.Context) {
+	batchID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var batch asPublishBatch
+	err := svc.DB.Preload("StaffMember").Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id asc") }).
+		Preload("Items.Metadata", func(db *gorm.DB) *gorm.DB { return db.Select("id", "pid", "title") }).
+		Limit(1).Find(&batch, batchID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace publish batch %d: %s", batchID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if batch.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("publish batch %d not found", batchID))
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// getArchivesSpacePublishUnits lists the units with master files for an archivesspace record. The unit that will
+// be published, if one can be determined, is flagged as selected. A selection the jobs service will not honor is
+// reported as an error.
+func (svc *serviceContext) getArchivesSpacePublishUnits(c *gin.Context) {
+	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var resp struct {
+		Candidates []asPublishUnitCandidate `json:"candidates"`
+		Error      string                   `json:"error,omitempty"`
+	}
+	err := svc.DB.Table("units u").
+		Joins("inner join master_files mf on mf.unit_id = u.id").
+		Joins("left join intended_uses iu on iu.id = u.intended_use_id").
+		Select("u.id as unit_id, u.order_id, coalesce(u.intended_use_id, 0) as intended_use_id, coalesce(iu.description, '') as intended_use, count(mf.id) as master_file_count").
+		Where("mf.metadata_id=?", mdID).Group("u.id").Order("u.id asc").Scan(&resp.Candidates).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace publish units for metadata %d: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tgtUnitID, err := svc.getASPublishUnitID(mdID)
+	if err != nil {
+		resp.Error = err.Error()
+	} else if _, err := svc.getASJobsPublishUnitID(mdID); errors.Is(err, errASUnitMismatch) {
+		resp.Error = err.Error()
+	}
+	for idx := range resp.Candidates {
+		resp.Candidates[idx].Selected = resp.Candidates[idx].UnitID == tgtUnitID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// selectArchivesSpacePublishUnit lets a supervisor choose the unit published for a record in archivesspace review,
+// including one that has already been published so it can be republished from another unit. A unitID of 0 clears the choice.
+func (svc *serviceContext) selectArchivesSpacePublishUnit(c *gin.Context) {
+	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	claims := getClaims(c)
+	if claims.Role != "admin" && claims.Role != "supervisor" {
+		log.Printf("INFO: %s with role %s cannot select an archivesspace publish unit", claims.ComputeID, claims.Role)
+		c.String(http.StatusForbidden, "only supervisors can select the publish unit")
+		return
+	}
+	var req struct {
+		UnitID int64 `json:"unitID"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid archivesspace publish unit request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var asR archivesspaceReview
+	err = svc.DB.Where("metadata_id=?", mdID).Limit(1).Find(&asR).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load submission info for metadata %d: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if asR.ID == 0 {
+		c.String(http.StatusBadRequest, fmt.Sprintf("metadata %d has not been submitted for archivesspace review", mdID))
+		return
+	}
+
+	if req.UnitID == 0 {
+		log.Printf("INFO: %s clears the publish unit for metadata %d", claims.ComputeID, mdID)
+		asR.UnitID = nil
+		asR.PublishUnitStaffID = nil
+	} else {
+		var mfCnt int64
+		err = svc.DB.Table("master_files").Where("unit_id=? and metadata_id=?", req.UnitID, mdID).Count(&mfCnt).Error
+		if err != nil {
+			log.Printf("ERROR: unable to validate publish unit %d for metadata %d: %s", req.UnitID, mdID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if mfCnt == 0 {
+			c.String(http.StatusBadRequest, fmt.Sprintf("unit %d has no master files for metadata %d", req.UnitID, mdID))
+			return
+		}
+		log.Printf("INFO: %s selects unit %d to publish metadata %d", claims.ComputeID, req.UnitID, mdID)
+		staffID := int64(claims.UserID)
+		asR.UnitID = &req.UnitID
+		asR.PublishUnitStaffID = &staffID
+	}
+	err = svc.DB.Model(&asR).Select("UnitID", "PublishUnitStaffID").Updates(&asR).Error
+	if err != nil {
+		log.Printf("ERROR: unable to set publish unit for metadata %d: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, asR)
+}
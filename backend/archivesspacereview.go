@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archivesspace review statuses
+const (
+	asRequested = "requested"
+	asReviewing = "review"
+	asRejected  = "rejected"
+	asPublished = "published"
+)
+
+// asReviewActions are the actions that move a review between statuses, in the order they are reported to the UI
+var asReviewActions = []string{"review", "publish", "reject", "resubmit", "cancel"}
+
+// errASAssignedReviewer is returned by checkAction when a submission is assigned to another reviewer
+var errASAssignedReviewer = errors.New("the submission is assigned to another reviewer")
+
+// checkAction returns an error describing why the staff member cannot perform the action on the review in its
+// current status. A submitter can never review, publish or reject their own submission, and a submission with
+// a reviewer can only be reviewed by that reviewer.
+func (asR *archivesspaceReview) checkAction(action string, staffID int64) error {
+	isReviewer := asR.ReviewStaffID != nil && *asR.ReviewStaffID == staffID
+	switch action {
+	case "review":
+		if asR.Status != asRequested && asR.Status != asReviewing {
+			return fmt.Errorf("a %s submission cannot be reviewed", asR.Status)
+		}
+		if staffID == asR.SubmitStaffID {
+			return fmt.Errorf("submitters cannot review their own submission")
+		}
+		if asR.ReviewStaffID != nil && isReviewer == false {
+			return errASAssignedReviewer
+		}
+	case "publish", "reject":
+		if asR.Status != asReviewing {
+			return fmt.Errorf("a %s submission cannot be %sed", asR.Status, action)
+		}
+		if staffID == asR.SubmitStaffID {
+			return fmt.Errorf("submitters cannot %s their own submission", action)
+		}
+		if isReviewer == false {
+			return fmt.Errorf("only the reviewer can %s a submission", action)
+		}
+	case "resubmit":
+		if asR.Status != asRejected {
+			return fmt.Errorf("a %s submission cannot be resubmitted", asR.Status)
+		}
+		if isReviewer {
+			return fmt.Errorf("reviewers cannot resubmit a submission they rejected")
+		}
+	case "cancel":
+		if asR.Status != asRequested && asR.Status != asRejected {
+			return fmt.Errorf("a %s submission cannot be canceled", asR.Status)
+		}
+	default:
+		return fmt.Errorf("%s is not a valid review action", action)
+	}
+	return nil
+}
+
+// setActions fills in the actions the staff member is allowed to take on the review
+func (asR *archivesspaceReview) setActions(staffID int64) {
+	asR.Actions = make([]string, 0)
+	for _, action := range asReviewActions {
+		if asR.checkAction(action, staffID) == nil {
+			asR.Actions = append(asR.Actions, action)
+		}
+	}
+}
+
+// assignArchivesSpaceReviews assigns a reviewer to each requested review that does not have one
+func (svc *serviceContext) assignArchivesSpaceReviews(c *gin.Context) {
+	log.Printf("INFO: assign reviewers to unassigned archivesspace reviews")
+	var pending []archivesspaceReview
+	err := svc.DB.Where("status=? and review_staff_id is null", asRequested).Order("submitted_at asc").Find(&pending).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get unassigned archivesspace reviews: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	claims := getClaims(c)
+	assigned := make([]archivesspaceReview, 0)
+	for idx := range pending {
+		asR := &pending[idx]
+		reviewer, err := svc.assignArchivesSpaceReviewer(asR)
+		if err != nil {
+			log.Printf("ERROR: unable to assign reviewer for metadata %d: %s", asR.MetadataID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if reviewer == nil {
+			log.Printf("INFO: no reviewers are available for metadata %d", asR.MetadataID)
+			continue
+		}
+		asR.setActions(int64(claims.UserID))
+		assigned = append(assigned, *asR)
+	}
+	log.Printf("INFO: %d of %d archivesspace reviews assigned", len(assigned), len(pending))
+	c.JSON(http.StatusOK, assigned)
+}
+
+// assignArchivesSpaceReviewer assigns the next supervisor, round-robin by most recent assignment, to a
+// requested review. The submitter is skipped. Nil is returned if there are no eligible supervisors.
+func (svc *serviceContext) assignArchivesSpaceReviewer(asR *archivesspaceReview) (*staffMember, error) {
+	var supervisors []staffMember
+	err := svc.DB.Where("role=? and is_active=?", supervisor, true).Order("id asc").Find(&supervisors).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to get supervisors: %s", err.Error())
+	}
+	supervisors = slices.DeleteFunc(supervisors, func(sm staffMember) bool { return int64(sm.ID) == asR.SubmitStaffID })
+	if len(supervisors) == 0 {
+		return nil, nil
+	}
+
+	var last archivesspaceReview
+	err = svc.DB.Where("assigned_at is not null").Order("assigned_at desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to get last review assignment: %s", err.Error())
+	}
+	next := supervisors[0]
+	if last.ReviewStaffID != nil {
+		for _, sm := range supervisors {
+			if int64(sm.ID) > *last.ReviewStaffID {
+				next = sm
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	reviewerID := int64(next.ID)
+	asR.ReviewStaffID = &reviewerID
+	asR.AssignedAt = &now
+	err = svc.DB.Model(asR).Select("ReviewStaffID", "AssignedAt").Updates(asR).Error
+	if err != nil {
+		return nil, err
+	}
+	asR.Reviewer = &next
+	log.Printf("INFO: archivesspace review for metadata %d assigned to %s", asR.MetadataID, next.ComputingID)
+	return &next, nil
+}
+
+type reviewComment struct {
+	ID                    int64        `json:"id"`
+	ArchivesspaceReviewID int64        `json:"reviewID"`
+	StaffMemberID         *int64       `json:"-"`
+	Author                *staffMember `gorm:"foreignKey:StaffMemberID" json:"author,omitempty"`
+	Action                string       `json:"action"`
+	Body                  string       `json:"body"`
+	CreatedAt             time.Time    `json:"createdAt"`
+}
+
+// getArchivesSpaceReviewComments returns the comment thread for the archivesspace review of a metadata record
+func (svc *serviceContext) getArchivesSpaceReviewComments(c *gin.Context) {
+	mdID := c.Param("id")
+	var comments []reviewComment
+	err := svc.DB.Preload("Author").
+		Joins("inner join archivesspace_reviews r on r.id = review_comments.archivesspace_review_id").
+		Where("r.metadata_id=?", mdID).Order("review_comments.created_at asc").Find(&comments).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace review comments for metadata %s: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// addArchivesSpaceReviewComment adds a note from the signed in staff member to the review thread
+func (svc *serviceContext) addArchivesSpaceReviewComment(c *gin.Context) {
+	mdID := c.Param("id")
+	var req struct {
+		Body string `json:"body"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid archivesspace review comment request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" {
+		c.String(http.StatusBadRequest, "comment is required")
+		return
+	}
+
+	var asR archivesspaceReview
+	err = svc.DB.Where("metadata_id=?", mdID).First(&asR).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load submission info for metadata %s: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	claims := getClaims(c)
+	log.Printf("INFO: user %s comments on archivesspace review for metadata %s", claims.ComputeID, mdID)
+	comment, err := svc.addReviewComment(&asR, int64(claims.UserID), "note", req.Body)
+	if err != nil {
+		log.Printf("ERROR: unable to add comment to archivesspace review for metadata %s: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, comment)
+}
+
+func (svc *serviceContext) addReviewComment(asR *archivesspaceReview, staffID int64, action, body string) (*reviewComment, error) {
+	comment := reviewComment{ArchivesspaceReviewID: asR.ID, Action: action, Body: body, CreatedAt: time.Now()}
+	if staffID > 0 {
+		comment.StaffMemberID = &staffID
+	}
+	err := svc.DB.Create(&comment).Error
+	if err != nil {
+		return nil, err
+	}
+	err = svc.DB.Preload("Author").First(&comment, comment.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+type asSubmitterRejections struct {
+	StaffID       int64   `json:"staffID"`
+	ComputingID   string  `json:"computingID"`
+	FirstName     string  `json:"firstName"`
+	LastName      string  `json:"lastName"`
+	Submitted     int64   `json:"submitted"`
+	Rejected      int64   `json:"rejected"`
+	RejectionRate float64 `json:"rejectionRate"`
+}
+
+type asWeeklyCount struct {
+	Week  string `json:"week"`
+	Count int64  `json:"count"`
+}
+
+// getArchivesSpaceStatusCounts returns the number of unpublished reviews in each status
+func (svc *serviceContext) getArchivesSpaceStatusCounts() (map[string]int64, error) {
+	var counts []struct {
+		Status string
+		Total  int64
+	}
+	err := svc.DB.Table("archivesspace_reviews").Select("status, count(*) as total").
+		Where("published_at is null").Group("status").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64)
+	for _, cnt := range counts {
+		out[cnt.Status] = cnt.Total
+	}
+	return out, nil
+}
+
+// getArchivesSpaceMetrics reports review queue status counts, median hours from submission to the start of review
+// and to publication, the rejection rate per submitter, and published counts for each of the last weeks (default 12)
+func (svc *serviceContext) getArchivesSpaceMetrics(c *gin.Context) {
+	weeks, _ := strconv.Atoi(c.Query("weeks"))
+	if weeks <= 0 {
+		weeks = 12
+	}
+	log.Printf("INFO: get archivesspace review metrics for %d weeks", weeks)
+
+	var resp struct {
+		Status               map[string]int64        `json:"status"`
+		MedianHoursToReview  float64                 `json:"medianHoursToReview"`
+		MedianHoursToPublish float64                 `json:"medianHoursToPublish"`
+		Submitters           []asSubmitterRejections `json:"submitters"`
+		Published            []asWeeklyCount         `json:"published"`
+	}
+	var err error
+	resp.Status, err = svc.getArchivesSpaceStatusCounts()
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace review status counts: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, endCol := range []string{"review_started_at", "published_at"} {
+		var hours []float64
+		err = svc.DB.Table("archivesspace_reviews").
+			Where(fmt.Sprintf("%s is not null", endCol)).
+			Pluck(fmt.Sprintf("timestampdiff(SECOND, submitted_at, %s)/3600", endCol), &hours).Error
+		if err != nil {
+			log.Printf("ERROR: unable to get archivesspace review times: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if endCol == "review_started_at" {
+			resp.MedianHoursToReview = medianValue(hours)
+		} else {
+			resp.MedianHoursToPublish = medianValue(hours)
+		}
+	}
+
+	// a submission counts as rejected if it is currently rejected or it has ever been rejected in its comment thread
+	rejectQ := "select s.id as staff_id, s.computing_id, s.first_name, s.last_name, count(r.id) as submitted,"
+	rejectQ += " sum(case when r.status='rejected' or exists (select 1 from review_comments rc"
+	rejectQ += " where rc.archivesspace_review_id=r.id and rc.action='rejected') then 1 else 0 end) as rejected"
+	rejectQ += " from archivesspace_reviews r inner join staff_members s on s.id=r.submit_staff_id"
+	rejectQ += " group by s.id order by s.last_name asc, s.first_name asc"
+	err = svc.DB.Raw(rejectQ).Scan(&resp.Submitters).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get archivesspace rejection rates: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for idx := range resp.Submitters {
+		sr := &resp.Submitters[idx]
+		if sr.Submitted > 0 {
+			sr.RejectionRate = math.Round(float64(sr.Rejected)/float64(sr.Submitted)*1000) / 10
+		}
+	}
+
+	// weeks start on monday; weeks with nothing published are reported with a zero count
+	now := time.Now()
+	thisWeek := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+	firstWeek := thisWeek.AddDate(0, 0, -7*(weeks-1))
+	var published []asWeeklyCount
+	err = svc.DB.Table("archivesspace_reviews").
+		Select("date_format(date_sub(date(published_at), interval weekday(published_at) day), '%Y-%m-%d') as week, count(*) as count").
+		Where("published_at >= ?", firstWeek).Group("week").Scan(&published).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get weekly archivesspace published counts: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp.Published = make([]asWeeklyCount, 0, weeks)
+	for week := firstWeek; week.After(thisWeek) == false; week = week.AddDate(0, 0, 7) {
+		wc := asWeeklyCount{Week: week.Format("2006-01-02")}
+		for _, pc := range published {
+			if pc.Week == wc.Week {
+				wc.Count = pc.Count
+			}
+		}
+		resp.Published = append(resp.Published, wc)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func medianValue(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	slices.Sort(vals)
+	mid := len(vals) / 2
+	median := vals[mid]
+	if len(vals)%2 == 0 {
+		median = (vals[mid-1] + vals[mid]) / 2
+	}
+	return math.Round(median*10) / 10
+}
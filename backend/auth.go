@@ -119,9 +119,18 @@ func (svc *serviceContext) authMiddleware(c *gin.Context) {
 
 	log.Printf("INFO: got valid bearer token: [%s] for %s", tokenStr, jwtClaims.ComputeID)
 	c.Set("jwt", tokenStr)
+	c.Set("claims", &jwtClaims)
 	c.Next()
 }
 
+func getClaims(c *gin.Context) *jwtClaims {
+	claimsIface, signedIn := c.Get("claims")
+	if !signedIn {
+		return &jwtClaims{}
+	}
+	return claimsIface.(*jwtClaims)
+}
+
 func getJWT(c *gin.Context) string {
 	jwtIface, signedIn := c.Get("jwt")
 	if !signedIn {
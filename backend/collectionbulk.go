@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bulkCollectionRequest identifies the records to add to a collection. Any combination of identifiers
+// may be used; an uploaded CSV supplies identifiers of a single type from its first column.
+type bulkCollectionRequest struct {
+	MetadataIDs     []int64  `json:"metadataIDs"`
+	PIDs            []string `json:"pids"`
+	UnitIDs         []int64  `json:"unitIDs"`
+	Barcodes        []string `json:"barcodes"`
+	ArchivesSpaceID int64    `json:"archivesSpaceID"` // ArchivesSpace resource id; members are matched by external URI
+}
+
+type bulkCollectionSummary struct {
+	JobID             uint64   `json:"jobID"`
+	Requested         int      `json:"requested"`
+	ToAdd             int      `json:"toAdd"`
+	AlreadyMembers    int      `json:"alreadyMembers"`
+	InOtherCollection []string `json:"inOtherCollection"`
+	Rejected          []string `json:"rejected"`
+	NotFound          []string `json:"notFound"`
+}
+
+type bulkCandidate struct {
+	ID               int64
+	PID              string `gorm:"column:pid"`
+	ParentMetadataID int64
+	IsCollection     bool
+	Barcode          string
+	ExternalURI      string
+}
+
+// addCollectionItems adds many records to a collection. The identifiers are resolved immediately so the
+// response can summarize what will happen; the membership updates are done by a tracked job.
+func (svc *serviceContext) addCollectionItems(c *gin.Context) {
+	collectionID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if collectionID == 0 {
+		log.Printf("ERROR: bad collection id %s in bulk add collection items request", c.Param("id"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid collection id %s", c.Param("id")))
+		return
+	}
+
+	var collectionMD metadata
+	err := svc.DB.Limit(1).Find(&collectionMD, collectionID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load collection %d: %s", collectionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if collectionMD.IsCollection == false {
+		log.Printf("ERROR: metadata %d is not a collection", collectionID)
+		c.String(http.StatusBadRequest, fmt.Sprintf("%d is not a collection", collectionID))
+		return
+	}
+
+	var req bulkCollectionRequest
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		err = svc.parseBulkCollectionCSV(c, &req)
+	} else {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		log.Printf("ERROR: invalid bulk add request for collection %d: %s", collectionID, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	out := bulkCollectionSummary{InOtherCollection: make([]string, 0), Rejected: make([]string, 0), NotFound: make([]string, 0)}
+	candidates := make(map[int64]bulkCandidate)
+	addFound := func(found []bulkCandidate) {
+		for _, md := range found {
+			candidates[md.ID] = md
+		}
+	}
+	mdFields := []string{"id", "pid", "parent_metadata_id", "is_collection", "barcode", "external_uri"}
+
+	if len(req.MetadataIDs) > 0 {
+		out.Requested += len(req.MetadataIDs)
+		var found []bulkCandidate
+		if err := svc.DB.Table("metadata").Select(mdFields).Where("id in ?", req.MetadataIDs).Find(&found).Error; err != nil {
+			log.Printf("ERROR: unable to find metadata by id: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		addFound(found)
+		for _, id := range req.MetadataIDs {
+			if _, ok := candidates[id]; ok == false {
+				out.NotFound = append(out.NotFound, fmt.Sprintf("metadata %d", id))
+			}
+		}
+	}
+
+	if len(req.PIDs) > 0 {
+		out.Requested += len(req.PIDs)
+		var found []bulkCandidate
+		if err := svc.DB.Table("metadata").Select(mdFields).Where("pid in ?", req.PIDs).Find(&found).Error; err != nil {
+			log.Printf("ERROR: unable to find metadata by pid: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		addFound(found)
+		for _, pid := range req.PIDs {
+			if slices.ContainsFunc(found, func(md bulkCandidate) bool { return strings.EqualFold(md.PID, pid) }) == false {
+				out.NotFound = append(out.NotFound, fmt.Sprintf("pid %s", pid))
+			}
+		}
+	}
+
+	if len(req.UnitIDs) > 0 {
+		out.Requested += len(req.UnitIDs)
+		var unitMD []struct {
+			UnitID     int64
+			MetadataID int64
+		}
+		if err := svc.DB.Table("units").Select("id as unit_id", "metadata_id").Where("id in ?", req.UnitIDs).Find(&unitMD).Error; err != nil {
+			log.Printf("ERROR: unable to find units: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		mdIDs := make([]int64, 0)
+		for _, unitID := range req.UnitIDs {
+			matched := false
+			for _, um := range unitMD {
+				if um.UnitID == unitID && um.MetadataID > 0 {
+					mdIDs = append(mdIDs, um.MetadataID)
+					matched = true
+				}
+			}
+			if matched == false {
+				out.NotFound = append(out.NotFound, fmt.Sprintf("unit %d", unitID))
+			}
+		}
+		if len(mdIDs) > 0 {
+			var found []bulkCandidate
+			if err := svc.DB.Table("metadata").Select(mdFields).Where("id in ?", mdIDs).Find(&found).Error; err != nil {
+				log.Printf("ERROR: unable to find unit metadata: %s", err.Error())
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			addFound(found)
+		}
+	}
+
+	if len(req.Barcodes) > 0 {
+		out.Requested += len(req.Barcodes)
+		var found []bulkCandidate
+		if err := svc.DB.Table("metadata").Select(mdFields).Where("barcode in ?", req.Barcodes).Find(&found).Error; err != nil {
+			log.Printf("ERROR: unable to find metadata by barcode: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		addFound(found)
+		for _, bc := range req.Barcodes {
+			if slices.ContainsFunc(found, func(md bulkCandidate) bool { return strings.EqualFold(md.Barcode, bc) }) == false {
+				out.NotFound = append(out.NotFound, fmt.Sprintf("barcode %s", bc))
+			}
+		}
+	}
+
+	if req.ArchivesSpaceID > 0 {
+		log.Printf("INFO: get records for archivesspace collection %d", req.ArchivesSpaceID)
+		raw, reqErr := svc.getRequest(fmt.Sprintf("%s/archivesspace/collections/%d/records", svc.ExternalSystems.Jobs, req.ArchivesSpaceID))
+		if reqErr != nil {
+			log.Printf("ERROR: unable to get archivesspace collection %d records: %s", req.ArchivesSpaceID, reqErr.Message)
+			c.String(reqErr.StatusCode, reqErr.Message)
+			return
+		}
+		uris := make([]string, 0)
+		for _, uri := range strings.Split(string(raw), "\n") {
+			if cleanURI := strings.TrimSpace(uri); cleanURI != "" {
+				uris = append(uris, cleanURI)
+			}
+		}
+		out.Requested += len(uris)
+		for start := 0; start < len(uris); start += 500 {
+			end := min(start+500, len(uris))
+			var found []bulkCandidate
+			err := svc.DB.Table("metadata").Select(mdFields).Where("external_system_id=? and external_uri in ?", 1, uris[start:end]).Find(&found).Error
+			if err != nil {
+				log.Printf("ERROR: unable to find archivesspace metadata: %s", err.Error())
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			addFound(found)
+			for _, uri := range uris[start:end] {
+				if slices.ContainsFunc(found, func(md bulkCandidate) bool { return md.ExternalURI == uri }) == false {
+					out.NotFound = append(out.NotFound, fmt.Sprintf("archivesspace %s", uri))
+				}
+			}
+		}
+	}
+
+	if out.Requested == 0 {
+		c.String(http.StatusBadRequest, "no records were specified")
+		return
+	}
+
+	addIDs := make([]int64, 0)
+	for _, md := range candidates {
+		if md.ParentMetadataID == collectionID {
+			out.AlreadyMembers++
+			continue
+		}
+		if md.ParentMetadataID != 0 {
+			out.InOtherCollection = append(out.InOtherCollection, fmt.Sprintf("%s is in collection %d", md.PID, md.ParentMetadataID))
+			continue
+		}
+		if md.ID == collectionID {
+			out.Rejected = append(out.Rejected, fmt.Sprintf("%s is the collection", md.PID))
+			continue
+		}
+		if md.IsCollection {
+			if err := svc.checkSubCollection(md.ID, collectionID); err != nil {
+				out.Rejected = append(out.Rejected, err.Error())
+				continue
+			}
+		}
+		addIDs = append(addIDs, md.ID)
+	}
+	out.ToAdd = len(addIDs)
+	log.Printf("INFO: bulk add to collection %d: %d requested, %d to add, %d already members", collectionID, out.Requested, out.ToAdd, out.AlreadyMembers)
+
+	if len(addIDs) == 0 {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+
+	js, err := svc.createJobStatus("AddCollectionItems", "Metadata", fmt.Sprintf("%d", collectionID))
+	if err != nil {
+		log.Printf("ERROR: unable to create bulk collection add job: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	out.JobID = js.ID
+
+	go func() {
+		svc.logJobEvent(js, 0, fmt.Sprintf("Add %d records to collection %s", len(addIDs), collectionMD.PID))
+		for _, msg := range out.NotFound {
+			svc.logJobEvent(js, 1, fmt.Sprintf("%s not found", msg))
+		}
+		for _, msg := range append(out.InOtherCollection, out.Rejected...) {
+			svc.logJobEvent(js, 1, fmt.Sprintf("Skipped: %s", msg))
+		}
+		added := int64(0)
+		for start := 0; start < len(addIDs); start += 50 {
+			end := min(start+50, len(addIDs))
+			// parent_metadata_id=0 guards against records that were added elsewhere after the request was validated
+			resp := svc.DB.Exec("update metadata set parent_metadata_id=?, updated_at=? where parent_metadata_id=? and id in ?",
+				collectionID, time.Now(), 0, addIDs[start:end])
+			if resp.Error != nil {
+				svc.finishJobStatus(js, fmt.Sprintf("Unable to add records to the collection: %s", resp.Error.Error()))
+				return
+			}
+			added += resp.RowsAffected
+		}
+		svc.logJobEvent(js, 0, fmt.Sprintf("%d records added to collection %s", added, collectionMD.PID))
+		svc.finishJobStatus(js, "")
+	}()
+
+	c.JSON(http.StatusOK, out)
+}
+
+// parseBulkCollectionCSV reads identifiers from the first column of an uploaded CSV. The form field type
+// is one of metadata, pid, unit or barcode; a header row is skipped if its first value is not an identifier.
+func (svc *serviceContext) parseBulkCollectionCSV(c *gin.Context, req *bulkCollectionRequest) error {
+	idType := c.PostForm("type")
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		return fmt.Errorf("file is required: %s", err.Error())
+	}
+	f, err := formFile.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	for rowNum := 0; ; rowNum++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid csv: %s", err.Error())
+		}
+		if len(row) == 0 {
+			continue
+		}
+		val := strings.TrimSpace(strings.TrimPrefix(row[0], "\ufeff"))
+		if val == "" {
+			continue
+		}
+		switch idType {
+		case "metadata", "unit":
+			id, _ := strconv.ParseInt(val, 10, 64)
+			if id == 0 {
+				if rowNum == 0 {
+					continue
+				}
+				return fmt.Errorf("row %d: invalid %s id %s", rowNum+1, idType, val)
+			}
+			if idType == "unit" {
+				req.UnitIDs = append(req.UnitIDs, id)
+			} else {
+				req.MetadataIDs = append(req.MetadataIDs, id)
+			}
+		case "pid":
+			if rowNum == 0 && strings.EqualFold(val, "pid") {
+				continue
+			}
+			req.PIDs = append(req.PIDs, val)
+		case "barcode":
+			if rowNum == 0 && strings.EqualFold(val, "barcode") {
+				continue
+			}
+			req.Barcodes = append(req.Barcodes, val)
+		default:
+			return fmt.Errorf("type must be metadata, pid, unit or barcode, not [%s]", idType)
+		}
+	}
+	return nil
+}
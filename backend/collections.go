@@ -1,11 +1,16 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -35,7 +40,9 @@ func (svc *serviceContext) getCollections(c *gin.Context) {
 		CatalogKey   string `json:"catalogKey"`
 		CreatorName  string `json:"creatorName"`
 		CollectionID string `gorm:"column:collection_id" json:"collectionID"`
+		ParentID     int64  `gorm:"column:parent_metadata_id" json:"parentID"`
 		RecordCount  int64  `json:"recordCount"`
+		TotalCount   int64  `gorm:"-" json:"totalCount"`
 	}
 	var resp struct {
 		Total       int64           `json:"total"`
@@ -61,14 +68,150 @@ func (svc *serviceContext) getCollections(c *gin.Context) {
 		return
 	}
 
+	log.Printf("INFO: get collection member counts including sub-collections")
+	nodes, err := svc.loadCollectionTree()
+	if err != nil {
+		log.Printf("ERROR: unable to get collection hierarchy %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for idx := range resp.Collections {
+		hit := &resp.Collections[idx]
+		hit.TotalCount = hit.RecordCount
+		if node, ok := nodes[int64(hit.ID)]; ok {
+			hit.TotalCount = node.TotalCount
+		}
+	}
+
 	c.JSON(http.StatusOK, resp)
 }
 
-func (svc *serviceContext) getCollectionUnits(collectionID int64) ([]*unit, error) {
+// collectionNode is a collection in the collection hierarchy. RecordCount is the number of direct
+// members, including sub-collections; TotalCount also includes the members of all sub-collections.
+type collectionNode struct {
+	ID          int64             `json:"id"`
+	PID         string            `gorm:"column:pid" json:"pid"`
+	Title       string            `json:"title"`
+	ParentID    int64             `gorm:"column:parent_metadata_id" json:"parentID"`
+	RecordCount int64             `json:"recordCount"`
+	TotalCount  int64             `gorm:"-" json:"totalCount"`
+	Children    []*collectionNode `gorm:"-" json:"children"`
+}
+
+func (svc *serviceContext) getCollectionTree(c *gin.Context) {
+	log.Printf("INFO: get collection hierarchy")
+	nodes, err := svc.loadCollectionTree()
+	if err != nil {
+		log.Printf("ERROR: unable to get collection hierarchy %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]*collectionNode, 0)
+	for _, node := range nodes {
+		if _, hasParent := nodes[node.ParentID]; hasParent == false || node.ParentID == node.ID {
+			out = append(out, node)
+		}
+	}
+	sortCollectionNodes(out)
+	c.JSON(http.StatusOK, out)
+}
+
+// loadCollectionTree loads all collections with their direct member counts, links each to its parent
+// collection and calculates the recursive member counts. The result is keyed by collection id.
+func (svc *serviceContext) loadCollectionTree() (map[int64]*collectionNode, error) {
+	var nodes []*collectionNode
+	err := svc.DB.Table("metadata").
+		Joins("left join metadata mc on mc.parent_metadata_id = metadata.id").
+		Select("metadata.id", "metadata.pid", "metadata.title", "metadata.parent_metadata_id", "count(mc.id) as record_count").
+		Where("metadata.is_collection=?", true).Group("metadata.id").Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[int64]*collectionNode)
+	for _, node := range nodes {
+		node.Children = make([]*collectionNode, 0)
+		out[node.ID] = node
+	}
+	for _, node := range nodes {
+		if parent, ok := out[node.ParentID]; ok && node.ParentID != node.ID {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	visited := make(map[int64]bool)
+	for _, node := range nodes {
+		if _, hasParent := out[node.ParentID]; hasParent == false {
+			node.countMembers(visited)
+		}
+	}
+	for _, node := range nodes {
+		if visited[node.ID] == false {
+			// only collections that are part of a parent loop are left; count them so they still report totals
+			log.Printf("WARNING: collection %d is part of a collection loop", node.ID)
+			node.countMembers(visited)
+		}
+		sortCollectionNodes(node.Children)
+	}
+	return out, nil
+}
+
+func (node *collectionNode) countMembers(visited map[int64]bool) int64 {
+	if visited[node.ID] {
+		return 0
+	}
+	visited[node.ID] = true
+	node.TotalCount = node.RecordCount
+	for _, child := range node.Children {
+		node.TotalCount += child.countMembers(visited)
+	}
+	return node.TotalCount
+}
+
+func sortCollectionNodes(nodes []*collectionNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return strings.ToLower(nodes[i].Title) < strings.ToLower(nodes[j].Title)
+	})
+}
+
+// getCollectionIDs returns the id of the collection followed by the ids of all of its sub-collections
+func (svc *serviceContext) getCollectionIDs(collectionID int64) ([]int64, error) {
+	out := []int64{collectionID}
+	visited := map[int64]bool{collectionID: true}
+	frontier := []int64{collectionID}
+	for len(frontier) > 0 {
+		var childIDs []int64
+		err := svc.DB.Table("metadata").Where("is_collection=? and parent_metadata_id in ?", true, frontier).Pluck("id", &childIDs).Error
+		if err != nil {
+			return nil, fmt.Errorf("unable to get sub-collections of %d: %s", collectionID, err.Error())
+		}
+		frontier = make([]int64, 0)
+		for _, id := range childIDs {
+			if visited[id] == false {
+				visited[id] = true
+				out = append(out, id)
+				frontier = append(frontier, id)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (svc *serviceContext) getCollectionUnits(collectionID int64, includeDescendants bool) ([]*unit, error) {
 	out := make([]*unit, 0)
 
+	collectionIDs := []int64{collectionID}
+	if includeDescendants {
+		ids, err := svc.getCollectionIDs(collectionID)
+		if err != nil {
+			return out, err
+		}
+		collectionIDs = ids
+	}
+
 	var inCollectionIDs []uint64
-	if err := svc.DB.Raw("select id from metadata where parent_metadata_id=?", collectionID).Scan(&inCollectionIDs).Error; err != nil {
+	if err := svc.DB.Raw("select id from metadata where parent_metadata_id in ?", collectionIDs).Scan(&inCollectionIDs).Error; err != nil {
 		return out, err
 	}
 	// NOTE: Manually calculate the master files count and return it as num_master_files instead of using the inaccurate cache
@@ -127,20 +270,31 @@ func (svc *serviceContext) getCollectionItems(c *gin.Context) {
 		queryClause = svc.DB.Where("title like ? ", qLike)
 	}
 
-	log.Printf("INFO: get collection records for collection %d, start %d limit %d, order %s, query [%s]", collectionID, startIndex, pageSize, orderStr, qStr)
+	collectionIDs := []int64{collectionID}
+	if c.Query("descendants") == "true" {
+		ids, err := svc.getCollectionIDs(collectionID)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		collectionIDs = ids
+	}
+
+	log.Printf("INFO: get collection records for collections %v, start %d limit %d, order %s, query [%s]", collectionIDs, startIndex, pageSize, orderStr, qStr)
 
 	var resp struct {
 		Metadata []metadata `json:"records"`
 		Total    int64      `json:"total"`
 	}
 	if queryClause != nil {
-		err := svc.DB.Table("metadata").Where("parent_metadata_id=?", collectionID).Where(queryClause).Count(&resp.Total).Error
+		err := svc.DB.Table("metadata").Where("parent_metadata_id in ?", collectionIDs).Where(queryClause).Count(&resp.Total).Error
 		if err != nil {
 			log.Printf("ERROR: unable to get filtered collection records count for collection %d: %s", collectionID, err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
-		err = svc.DB.Where("parent_metadata_id=?", collectionID).Where(queryClause).
+		err = svc.DB.Where("parent_metadata_id in ?", collectionIDs).Where(queryClause).
 			Offset(startIndex).Limit(pageSize).Order(orderStr).Find(&resp.Metadata).Error
 		if err != nil {
 			log.Printf("ERROR: unable to get filtered collection records for collection %d: %s", collectionID, err.Error())
@@ -148,13 +302,13 @@ func (svc *serviceContext) getCollectionItems(c *gin.Context) {
 			return
 		}
 	} else {
-		err := svc.DB.Table("metadata").Where("parent_metadata_id=?", collectionID).Count(&resp.Total).Error
+		err := svc.DB.Table("metadata").Where("parent_metadata_id in ?", collectionIDs).Count(&resp.Total).Error
 		if err != nil {
 			log.Printf("ERROR: unable to get collection records count for collection %d: %s", collectionID, err.Error())
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
-		err = svc.DB.Where("parent_metadata_id=?", collectionID).
+		err = svc.DB.Where("parent_metadata_id in ?", collectionIDs).
 			Offset(startIndex).Limit(pageSize).Order(orderStr).Find(&resp.Metadata).Error
 		if err != nil {
 			log.Printf("ERROR: unable to get collection records for collection %d: %s", collectionID, err.Error())
@@ -255,9 +409,16 @@ func (svc *serviceContext) addCollectionItem(c *gin.Context) {
 
 	if md.ParentMetadataID != 0 {
 		log.Printf("INFO: invalid request to add metadata %d to collection %d; it is already in collection %d", mdID, collectionID, md.ParentMetadataID)
-		c.String(http.StatusBadRequest, fmt.Sprintf("this record is already part of collection %d", md.ParentMetadataID))
+		c.String(http.StatusBadRequest, fmt.Sprintf("this record is already part of collection %d; move it instead", md.ParentMetadataID))
 		return
 	}
+	if md.IsCollection {
+		if err := svc.checkSubCollection(md.ID, collectionID); err != nil {
+			log.Printf("INFO: invalid request to add collection %d to collection %d: %s", mdID, collectionID, err.Error())
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	md.ParentMetadataID = collectionID
 	err = svc.DB.Model(&md).Select("ParentMetadataID").Updates(md).Error
@@ -270,6 +431,85 @@ func (svc *serviceContext) addCollectionItem(c *gin.Context) {
 	c.String(http.StatusOK, fmt.Sprintf("metadata has been to collection %d", collectionID))
 }
 
+func (svc *serviceContext) moveCollectionItem(c *gin.Context) {
+	collectionID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if collectionID == 0 {
+		log.Printf("ERROR: bad collection id %s in move collection item request", c.Param("id"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid collection id %s", c.Param("id")))
+		return
+	}
+	itemID, _ := strconv.ParseInt(c.Param("item"), 10, 64)
+	if itemID == 0 {
+		log.Printf("ERROR: bad item id %s in move collection item request", c.Param("item"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid collection item id %s", c.Param("item")))
+		return
+	}
+	tgtCollectionID, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	if tgtCollectionID == 0 {
+		log.Printf("ERROR: bad target collection id %s in move collection item request", c.Query("to"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid target collection id %s", c.Query("to")))
+		return
+	}
+
+	log.Printf("INFO: move item %d from collection %d to collection %d", itemID, collectionID, tgtCollectionID)
+	var tgtItem metadata
+	err := svc.DB.Limit(1).Find(&tgtItem, itemID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load collection item %d: %s", itemID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tgtItem.ParentMetadataID != collectionID {
+		log.Printf("ERROR: item %d is not part of collection %d", itemID, collectionID)
+		c.String(http.StatusBadRequest, fmt.Sprintf("item %d is not part of collection %d", itemID, collectionID))
+		return
+	}
+
+	var tgtCollection metadata
+	err = svc.DB.Limit(1).Find(&tgtCollection, tgtCollectionID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load target collection %d: %s", tgtCollectionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tgtCollection.IsCollection == false {
+		log.Printf("ERROR: move target %d is not a collection", tgtCollectionID)
+		c.String(http.StatusBadRequest, fmt.Sprintf("%d is not a collection", tgtCollectionID))
+		return
+	}
+	if tgtItem.IsCollection {
+		if err := svc.checkSubCollection(tgtItem.ID, tgtCollectionID); err != nil {
+			log.Printf("ERROR: unable to move collection %d to %d: %s", itemID, tgtCollectionID, err.Error())
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	tgtItem.ParentMetadataID = tgtCollectionID
+	tgtItem.UpdatedAt = &now
+	err = svc.DB.Model(&tgtItem).Select("ParentMetadataID", "UpdatedAt").Updates(tgtItem).Error
+	if err != nil {
+		log.Printf("ERROR: unable to move %d to collection %d: %s", itemID, tgtCollectionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, tgtItem)
+}
+
+// checkSubCollection returns an error if placing the sub-collection in the parent collection would
+// make a collection a member of itself
+func (svc *serviceContext) checkSubCollection(subCollectionID, parentID int64) error {
+	ids, err := svc.getCollectionIDs(subCollectionID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(ids, parentID) {
+		return fmt.Errorf("collection %d cannot be placed in %d; it would become part of itself", subCollectionID, parentID)
+	}
+	return nil
+}
+
 func (svc *serviceContext) addCollectionFacet(c *gin.Context) {
 	var req struct {
 		Facet string `json:"facet"`
@@ -301,29 +541,157 @@ func (svc *serviceContext) addCollectionFacet(c *gin.Context) {
 	c.JSON(http.StatusOK, out)
 }
 
+// collectionExportColumn is a column available in the collection export. The select expression is
+// evaluated per member record (aliased as m) and must produce a value that can be scanned as a string.
+type collectionExportColumn struct {
+	Name   string
+	Select string
+	Args   func(svc *serviceContext) []any
+}
+
+var collectionExportColumns = []collectionExportColumn{
+	{Name: "pid", Select: "m.pid"},
+	{Name: "title", Select: "m.title"},
+	{Name: "callNumber", Select: "m.call_number"},
+	{Name: "barcode", Select: "m.barcode"},
+	{Name: "catalogKey", Select: "m.catalog_key"},
+	{Name: "creator", Select: "m.creator_name"},
+	{Name: "dateDLIngest", Select: "date_format(m.date_dl_ingest, '%Y-%m-%d')"},
+	{Name: "dpla", Select: "if(m.dpla=1, 'true', 'false')"},
+	{Name: "hathiTrustStatus", Select: "(select hs.package_status from hathitrust_statuses hs where hs.metadata_id=m.id limit 1)"},
+	{Name: "unitIDs", Select: "(select group_concat(u.id order by u.id separator ';') from units u where u.metadata_id=m.id)"},
+	{Name: "masterFileCount", Select: "(select count(f.id) from master_files f where f.metadata_id=m.id)"},
+	{Name: "thumbnailURL",
+		Select: "(select concat(?, '/', f.pid, '/full/!240,385/0/default.jpg') from master_files f where f.metadata_id=m.id and f.exemplar=1 limit 1)",
+		Args:   func(svc *serviceContext) []any { return []any{svc.ExternalSystems.IIIF} }},
+	{Name: "virgoURL",
+		Select: "case when m.date_dl_ingest is null then null when m.type='SirsiMetadata' then concat(?, m.catalog_key) when m.type='XmlMetadata' then concat(?, m.pid) end",
+		Args: func(svc *serviceContext) []any {
+			return []any{fmt.Sprintf("%s/sources/uva_library/items/", svc.ExternalSystems.Virgo), fmt.Sprintf("%s/sources/images/items/", svc.ExternalSystems.Virgo)}
+		}},
+}
+
+// exportCollectionCSV streams the members of a collection. The columns param is a comma separated list of
+// column names (default pid), format is csv, xlsx (csv with a BOM and formula escaping for Excel) or jsonl,
+// and descendants=true includes the members of sub-collections.
 func (svc *serviceContext) exportCollectionCSV(c *gin.Context) {
 	collectionID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	if collectionID == 0 {
-		log.Printf("ERROR: bad collection id %s in add collection items request", c.Param("id"))
+		log.Printf("ERROR: bad collection id %s in export collection request", c.Param("id"))
 		c.String(http.StatusBadRequest, fmt.Sprintf("invalid collection id %s", c.Param("id")))
 		return
 	}
-	log.Printf("INFO: export a list of pids for items in collection %d", collectionID)
-	var resp []metadata
-	err := svc.DB.Select("pid").Where("parent_metadata_id=?", collectionID).Find(&resp).Error
+	format := c.Query("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" && format != "jsonl" {
+		log.Printf("ERROR: unsupported collection export format %s", format)
+		c.String(http.StatusBadRequest, fmt.Sprintf("unsupported format %s", format))
+		return
+	}
+
+	colNames := []string{"pid"}
+	if c.Query("columns") != "" {
+		colNames = strings.Split(c.Query("columns"), ",")
+	}
+	selects := make([]string, 0)
+	args := make([]any, 0)
+	for idx, name := range colNames {
+		name = strings.TrimSpace(name)
+		colIdx := slices.IndexFunc(collectionExportColumns, func(col collectionExportColumn) bool { return col.Name == name })
+		if colIdx == -1 {
+			log.Printf("ERROR: unknown collection export column %s", name)
+			c.String(http.StatusBadRequest, fmt.Sprintf("unknown column %s", name))
+			return
+		}
+		col := collectionExportColumns[colIdx]
+		colNames[idx] = name
+		selects = append(selects, fmt.Sprintf("%s as col%d", col.Select, idx))
+		if col.Args != nil {
+			args = append(args, col.Args(svc)...)
+		}
+	}
+
+	collectionIDs := []int64{collectionID}
+	if c.Query("descendants") == "true" {
+		ids, err := svc.getCollectionIDs(collectionID)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		collectionIDs = ids
+	}
+
+	log.Printf("INFO: export %s with columns %v for items in collections %v", format, colNames, collectionIDs)
+	q := fmt.Sprintf("select %s from metadata m where m.parent_metadata_id in ? order by m.id asc", strings.Join(selects, ", "))
+	args = append(args, collectionIDs)
+	rows, err := svc.DB.Raw(q, args...).Rows()
 	if err != nil {
 		log.Printf("ERROR: unable to get collection %d items: %s", collectionID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer rows.Close()
+
+	fileName := fmt.Sprintf("collection-%d.csv", collectionID)
+	if format == "jsonl" {
+		fileName = fmt.Sprintf("collection-%d.jsonl", collectionID)
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
 
-	c.Header("Content-Type", "text/csv")
 	cw := csv.NewWriter(c.Writer)
-	csvHead := []string{"pid"}
-	cw.Write(csvHead)
-	for _, md := range resp {
-		line := []string{fmt.Sprintf("%s", md.PID)}
-		cw.Write(line)
+	jw := json.NewEncoder(c.Writer)
+	switch format {
+	case "xlsx":
+		c.Writer.WriteString("\ufeff")
+		cw.UseCRLF = true
+		cw.Write(colNames)
+	case "csv":
+		cw.Write(colNames)
+	}
+
+	vals := make([]sql.NullString, len(colNames))
+	ptrs := make([]any, len(colNames))
+	for idx := range vals {
+		ptrs[idx] = &vals[idx]
+	}
+	cnt := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			// the response has already started so the failure can only be logged
+			log.Printf("ERROR: unable to read collection %d export row: %s", collectionID, err.Error())
+			break
+		}
+		if format == "jsonl" {
+			rec := make(map[string]*string)
+			for idx, name := range colNames {
+				rec[name] = nil
+				if vals[idx].Valid {
+					rec[name] = &vals[idx].String
+				}
+			}
+			jw.Encode(rec)
+		} else {
+			line := make([]string, len(colNames))
+			for idx := range vals {
+				line[idx] = vals[idx].String
+				if format == "xlsx" && line[idx] != "" && strings.ContainsAny(line[idx][:1], "=+-@") {
+					line[idx] = "'" + line[idx]
+				}
+			}
+			cw.Write(line)
+		}
+		cnt++
+		if cnt%500 == 0 {
+			cw.Flush()
+			c.Writer.Flush()
+		}
 	}
 	cw.Flush()
+	log.Printf("INFO: exported %d items from collection %d", cnt, collectionID)
 }
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type componentType struct {
@@ -19,7 +22,7 @@ type componentType struct {
 }
 
 type component struct {
-	MasterFileCount   int64         `gorm:"column:mf_cnt" json:"masterFileCount"`
+	MasterFileCount   int64         `gorm:"column:mf_cnt;->" json:"masterFileCount"`
 	ID                int64         `json:"id"`
 	ParentComponentID int64         `json:"-"`
 	PID               string        `gorm:"column:pid" json:"pid"`
@@ -34,6 +37,36 @@ type component struct {
 	ComponentTypeID   int64         `json:"-"`
 	ComponentType     componentType `gorm:"foreignKey:ComponentTypeID" json:"componentType"`
 	Children          []*component  `gorm:"-" json:"children,omitempty"`
+	CreatedAt         *time.Time    `json:"-"`
+	UpdatedAt         *time.Time    `json:"-"`
+}
+
+func (cmp *component) AfterCreate(tx *gorm.DB) (err error) {
+	if cmp.PID != "" {
+		return nil
+	}
+	cmp.PID = fmt.Sprintf("tsc:%d", cmp.ID)
+	return tx.Model(cmp).Update("pid", cmp.PID).Error
+}
+
+// childAncestry is the ancestry path for the direct children of this component
+func (cmp *component) childAncestry() string {
+	if cmp.Ancestry == "" {
+		return fmt.Sprintf("%d", cmp.ID)
+	}
+	return fmt.Sprintf("%s/%d", cmp.Ancestry, cmp.ID)
+}
+
+type componentRequest struct {
+	ParentID        int64  `json:"parentID"`
+	ComponentTypeID int64  `json:"componentTypeID"`
+	Title           string `json:"title"`
+	Label           string `json:"label"`
+	Description     string `json:"description"`
+	Date            string `json:"date"`
+	Level           string `json:"level"`
+	Barcode         string `json:"barcode"`
+	EadID           string `json:"eadID"`
 }
 
 func (svc *serviceContext) getComponentTree(c *gin.Context) {
@@ -70,41 +103,13 @@ func (svc *serviceContext) getComponentTree(c *gin.Context) {
 	}
 
 	log.Printf("INFO: get all children for component %d", topComponent.ID)
-	var children []*component
-	err = svc.DB.Preload("ComponentType").Where("ancestry = ? or ancestry like ?", fmt.Sprintf("%d", topComponent.ID), fmt.Sprintf("%d/%%", topComponent.ID)).
-		Select("components.*", subQ).Order("id asc").Find(&children).Error
+	err = svc.loadComponentChildren(topComponent)
 	if err != nil {
 		log.Printf("ERROR: unable to get children of %d: %s", topComponent.ID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("INFO: arrange %d children into a hierarchy", len(children))
-	for _, c := range children {
-		ancestryBits := strings.Split(c.Ancestry, "/")
-		if len(ancestryBits) == 1 {
-			// log.Printf("INFO: component %d is top level child of %d", c.ID, topComponent.ID)
-			topComponent.Children = append(topComponent.Children, c)
-		} else {
-			// toss the head as that is the topComponent and not part of the children array
-			ancestryBits = ancestryBits[1:]
-			// log.Printf("INFO: component %d is nested child of %d: %v", c.ID, topComponent.ID, ancestryBits)
-			parentComponent := topComponent
-			for _, aID := range ancestryBits {
-				tgtID, _ := strconv.ParseInt(aID, 10, 64)
-				for _, pc := range parentComponent.Children {
-					if pc.ID == tgtID {
-						// log.Printf("INFO: found matching child %d in parent component %d", pc.ID, parentComponent.ID)
-						parentComponent = pc
-						break
-					}
-				}
-			}
-			// log.Printf("INFO: add %d to parent %d", c.ID, parentComponent.ID)
-			parentComponent.Children = append(parentComponent.Children, c)
-		}
-	}
-
 	log.Printf("INFO: find masterfiles related to componend %d", cID)
 	var related []*masterFile
 	err = svc.DB.Preload("Metadata").Where("component_id=?", cID).Find(&related).Error
@@ -130,6 +135,36 @@ func (svc *serviceContext) getComponentTree(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// loadComponentChildren loads all descendants of the component and arranges them into a hierarchy
+// under it. The parent of each descendant is the last id in its ancestry path.
+func (svc *serviceContext) loadComponentChildren(top *component) error {
+	path := top.childAncestry()
+	subQ := "(select count(*) from master_files m where component_id=components.id) as mf_cnt"
+	var children []*component
+	err := svc.DB.Preload("ComponentType").Where("ancestry = ? or ancestry like ?", path, fmt.Sprintf("%s/%%", path)).
+		Select("components.*", subQ).Order("id asc").Find(&children).Error
+	if err != nil {
+		return err
+	}
+
+	log.Printf("INFO: arrange %d children of component %d into a hierarchy", len(children), top.ID)
+	byID := map[int64]*component{top.ID: top}
+	for _, child := range children {
+		byID[child.ID] = child
+	}
+	for _, child := range children {
+		ancestryBits := strings.Split(child.Ancestry, "/")
+		parentID, _ := strconv.ParseInt(ancestryBits[len(ancestryBits)-1], 10, 64)
+		parent, ok := byID[parentID]
+		if ok == false {
+			log.Printf("WARNING: component %d parent %d is not part of the tree under %d", child.ID, parentID, top.ID)
+			continue
+		}
+		parent.Children = append(parent.Children, child)
+	}
+	return nil
+}
+
 func (svc *serviceContext) getComponentViewerURL(mf *masterFile, idx int) string {
 	viewerURL := fmt.Sprintf("%s/%s/full/full/0/default.jpg", svc.ExternalSystems.IIIF, mf.PID)
 	if mf.MetadataID != nil {
@@ -170,3 +205,412 @@ func (svc *serviceContext) getComponentMasterFiles(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, related)
 }
+
+func (svc *serviceContext) createComponent(c *gin.Context) {
+	var req componentRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid create component request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Title) == "" && strings.TrimSpace(req.Label) == "" {
+		c.String(http.StatusBadRequest, "title or label is required")
+		return
+	}
+	if svc.validComponentType(req.ComponentTypeID) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid component type %d", req.ComponentTypeID))
+		return
+	}
+
+	newCmp := component{ComponentTypeID: req.ComponentTypeID, Title: req.Title, Label: req.Label, ContentDesc: req.Description,
+		Date: req.Date, Level: req.Level, Barcode: req.Barcode, EadIDAtt: req.EadID}
+	if req.ParentID > 0 {
+		var parent component
+		err = svc.DB.Limit(1).Find(&parent, req.ParentID).Error
+		if err != nil {
+			log.Printf("ERROR: unable to load parent component %d: %s", req.ParentID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if parent.ID == 0 {
+			c.String(http.StatusNotFound, fmt.Sprintf("parent component %d not found", req.ParentID))
+			return
+		}
+		newCmp.ParentComponentID = parent.ID
+		newCmp.Ancestry = parent.childAncestry()
+	}
+
+	log.Printf("INFO: create component [%s] with parent %d", req.Title, req.ParentID)
+	err = svc.DB.Omit(clause.Associations).Create(&newCmp).Error
+	if err != nil {
+		log.Printf("ERROR: unable to create component: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.DB.Preload("ComponentType").Find(&newCmp, newCmp.ID)
+	c.JSON(http.StatusOK, newCmp)
+}
+
+func (svc *serviceContext) updateComponent(c *gin.Context) {
+	cID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var req componentRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid update component %d request: %s", cID, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if svc.validComponentType(req.ComponentTypeID) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid component type %d", req.ComponentTypeID))
+		return
+	}
+
+	var cmp component
+	err = svc.DB.Limit(1).Find(&cmp, cID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cmp.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("%d not found", cID))
+		return
+	}
+
+	log.Printf("INFO: update component %d", cID)
+	cmp.ComponentTypeID = req.ComponentTypeID
+	cmp.Title = req.Title
+	cmp.Label = req.Label
+	cmp.ContentDesc = req.Description
+	cmp.Date = req.Date
+	cmp.Level = req.Level
+	cmp.Barcode = req.Barcode
+	cmp.EadIDAtt = req.EadID
+	err = svc.DB.Model(&cmp).Select("ComponentTypeID", "Title", "Label", "ContentDesc", "Date", "Level", "Barcode", "EadIDAtt", "UpdatedAt").
+		Updates(cmp).Error
+	if err != nil {
+		log.Printf("ERROR: unable to update component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.DB.Preload("ComponentType").Find(&cmp, cmp.ID)
+	c.JSON(http.StatusOK, cmp)
+}
+
+// moveComponent re-parents a component and rewrites the ancestry of its whole subtree. A parent of 0
+// makes the component the top of a new tree.
+func (svc *serviceContext) moveComponent(c *gin.Context) {
+	cID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	parentID, _ := strconv.ParseInt(c.Query("parent"), 10, 64)
+	var cmp component
+	err := svc.DB.Limit(1).Find(&cmp, cID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cmp.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("%d not found", cID))
+		return
+	}
+	if cmp.ParentComponentID == parentID {
+		c.String(http.StatusBadRequest, fmt.Sprintf("component %d is already a child of %d", cID, parentID))
+		return
+	}
+
+	newAncestry := ""
+	if parentID > 0 {
+		var parent component
+		err = svc.DB.Limit(1).Find(&parent, parentID).Error
+		if err != nil {
+			log.Printf("ERROR: unable to load parent component %d: %s", parentID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if parent.ID == 0 {
+			c.String(http.StatusNotFound, fmt.Sprintf("parent component %d not found", parentID))
+			return
+		}
+		newAncestry = parent.childAncestry()
+		if parent.ID == cmp.ID || slices.Contains(strings.Split(parent.Ancestry, "/"), fmt.Sprintf("%d", cmp.ID)) {
+			log.Printf("ERROR: unable to move component %d into its own subtree at %d", cID, parentID)
+			c.String(http.StatusBadRequest, "a component cannot be moved into itself or one of its children")
+			return
+		}
+	}
+
+	oldPath := cmp.childAncestry()
+	cmp.ParentComponentID = parentID
+	cmp.Ancestry = newAncestry
+	newPath := cmp.childAncestry()
+	log.Printf("INFO: move component %d to parent %d; subtree ancestry %s becomes %s", cID, parentID, oldPath, newPath)
+
+	var moved int64
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&cmp).Select("ParentComponentID", "Ancestry", "UpdatedAt").Updates(cmp).Error
+		if err != nil {
+			return err
+		}
+		resp := tx.Exec("update components set ancestry=concat(?, substring(ancestry, ?)) where ancestry=? or ancestry like ?",
+			newPath, len(oldPath)+1, oldPath, fmt.Sprintf("%s/%%", oldPath))
+		moved = resp.RowsAffected
+		return resp.Error
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to move component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: component %d moved along with %d descendants", cID, moved)
+	c.JSON(http.StatusOK, gin.H{"id": cmp.ID, "parentID": parentID, "descendantsMoved": moved})
+}
+
+// deleteComponent deletes a component. Components with children are only deleted with recursive=true, and
+// nothing is deleted if the component or any of its children have master files.
+func (svc *serviceContext) deleteComponent(c *gin.Context) {
+	cID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var cmp component
+	err := svc.DB.Limit(1).Find(&cmp, cID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cmp.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("%d not found", cID))
+		return
+	}
+
+	path := cmp.childAncestry()
+	var subtreeIDs []int64
+	err = svc.DB.Table("components").Where("ancestry=? or ancestry like ?", path, fmt.Sprintf("%s/%%", path)).Pluck("id", &subtreeIDs).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get children of component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(subtreeIDs) > 0 && c.Query("recursive") != "true" {
+		c.String(http.StatusBadRequest, fmt.Sprintf("component %d has %d children; use recursive=true to delete them", cID, len(subtreeIDs)))
+		return
+	}
+	subtreeIDs = append(subtreeIDs, cmp.ID)
+
+	var mfCnt int64
+	err = svc.DB.Table("master_files").Where("component_id in ?", subtreeIDs).Count(&mfCnt).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get master file count for component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if mfCnt > 0 {
+		log.Printf("INFO: unable to delete component %d; %d master files are linked", cID, mfCnt)
+		c.String(http.StatusConflict, fmt.Sprintf("%d master files are linked to this component or its children", mfCnt))
+		return
+	}
+
+	var sirsiCnt int64
+	err = svc.DB.Table("sirsi_metadata_components").Where("component_id in ?", subtreeIDs).Count(&sirsiCnt).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get sirsi metadata count for component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if sirsiCnt > 0 {
+		log.Printf("INFO: unable to delete component %d; %d sirsi metadata records are linked", cID, sirsiCnt)
+		c.String(http.StatusConflict, fmt.Sprintf("%d sirsi metadata records are linked to this component or its children", sirsiCnt))
+		return
+	}
+
+	log.Printf("INFO: delete component %d and %d children", cID, len(subtreeIDs)-1)
+	err = svc.DB.Where("id in ?", subtreeIDs).Delete(&component{}).Error
+	if err != nil {
+		log.Printf("ERROR: unable to delete component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, fmt.Sprintf("%d components deleted", len(subtreeIDs)))
+}
+
+func (svc *serviceContext) validComponentType(typeID int64) bool {
+	var cnt int64
+	svc.DB.Model(&componentType{}).Where("id=?", typeID).Count(&cnt)
+	return cnt > 0
+}
+
+// assignComponentMasterFiles links a range of a unit's master files to a component, or removes the link
+// when unassign is set. The range is inclusive and uses the sequence number from the master file filename.
+func (svc *serviceContext) assignComponentMasterFiles(c *gin.Context) {
+	cID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var req struct {
+		UnitID   int64 `json:"unitID"`
+		Start    int   `json:"start"`
+		End      int   `json:"end"`
+		Unassign bool  `json:"unassign"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid assign master files request for component %d: %s", cID, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.UnitID == 0 || req.Start <= 0 || req.End < req.Start {
+		log.Printf("ERROR: invalid assign master files request for component %d: %+v", cID, req)
+		c.String(http.StatusBadRequest, "unit id and a valid start and end sequence are required")
+		return
+	}
+
+	var cmp component
+	err = svc.DB.Limit(1).Find(&cmp, cID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cmp.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("%d not found", cID))
+		return
+	}
+
+	var unitMasterFiles []masterFile
+	err = svc.DB.Select("id", "pid", "filename", "component_id").Where("unit_id=?", req.UnitID).Order("filename asc").Find(&unitMasterFiles).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get master files for unit %d: %s", req.UnitID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(unitMasterFiles) == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("unit %d has no master files", req.UnitID))
+		return
+	}
+
+	// the component must be in the finding aid linked to the unit metadata
+	if req.Unassign == false {
+		var mdID int64
+		err = svc.DB.Table("units").Where("id=?", req.UnitID).Pluck("metadata_id", &mdID).Error
+		if err != nil {
+			log.Printf("ERROR: unable to get metadata for unit %d: %s", req.UnitID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		rootIDs, err := svc.getMetadataComponentRoots(mdID)
+		if err != nil {
+			log.Printf("ERROR: unable to get component trees for metadata %d: %s", mdID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(rootIDs) == 0 {
+			c.String(http.StatusBadRequest, fmt.Sprintf("the metadata for unit %d is not linked to a component tree", req.UnitID))
+			return
+		}
+		if slices.Contains(rootIDs, cmp.rootID()) == false {
+			log.Printf("INFO: component %d tree %d is not linked to metadata %d trees %v", cmp.ID, cmp.rootID(), mdID, rootIDs)
+			c.String(http.StatusBadRequest, fmt.Sprintf("component %d is not part of the component tree linked to the metadata for unit %d", cmp.ID, req.UnitID))
+			return
+		}
+	}
+
+	// all components used by a unit must be part of the same finding aid
+	otherCmpIDs := make([]int64, 0)
+	for _, mf := range unitMasterFiles {
+		if mf.ComponentID > 0 && mf.ComponentID != cmp.ID && slices.Contains(otherCmpIDs, mf.ComponentID) == false {
+			otherCmpIDs = append(otherCmpIDs, mf.ComponentID)
+		}
+	}
+	if len(otherCmpIDs) > 0 && req.Unassign == false {
+		var others []component
+		err = svc.DB.Select("id", "ancestry").Where("id in ?", otherCmpIDs).Find(&others).Error
+		if err != nil {
+			log.Printf("ERROR: unable to get components for unit %d master files: %s", req.UnitID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, other := range others {
+			if other.rootID() != cmp.rootID() {
+				log.Printf("INFO: unit %d master files belong to component tree %d, not %d", req.UnitID, other.rootID(), cmp.rootID())
+				c.String(http.StatusBadRequest, fmt.Sprintf("unit %d master files are already assigned to components in a different tree (component %d)", req.UnitID, other.ID))
+				return
+			}
+		}
+	}
+
+	mfIDs := make([]int64, 0)
+	for _, mf := range unitMasterFiles {
+		baseName := strings.Split(mf.Filename, ".")[0]
+		nameBits := strings.Split(baseName, "_")
+		seq, cnvErr := strconv.Atoi(nameBits[len(nameBits)-1])
+		if cnvErr != nil {
+			log.Printf("WARNING: unable to parse sequence from master file %s filename %s", mf.PID, mf.Filename)
+			continue
+		}
+		if seq < req.Start || seq > req.End {
+			continue
+		}
+		if req.Unassign && mf.ComponentID != cmp.ID {
+			continue
+		}
+		mfIDs = append(mfIDs, mf.ID)
+	}
+	if len(mfIDs) == 0 {
+		c.String(http.StatusBadRequest, fmt.Sprintf("no master files in unit %d match sequence %d-%d", req.UnitID, req.Start, req.End))
+		return
+	}
+
+	var newCmpID any = cmp.ID
+	if req.Unassign {
+		log.Printf("INFO: remove %d master files from unit %d from component %d", len(mfIDs), req.UnitID, cmp.ID)
+		newCmpID = gorm.Expr("NULL")
+	} else {
+		log.Printf("INFO: set component of %d master files from unit %d to %d", len(mfIDs), req.UnitID, cmp.ID)
+	}
+	err = svc.DB.Table("master_files").Where("id in ?", mfIDs).Updates(map[string]any{"component_id": newCmpID, "updated_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("ERROR: unable to update master file component: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var mfCnt int64
+	svc.DB.Table("master_files").Where("component_id=?", cmp.ID).Count(&mfCnt)
+	c.JSON(http.StatusOK, gin.H{"updated": len(mfIDs), "masterFileCount": mfCnt})
+}
+
+// rootID is the id of the top level component of the tree this component belongs to
+// getMetadataComponentRoots returns the root ids of the component trees linked to a metadata record, either
+// through sirsi_metadata_components or by components already assigned to its master files
+func (svc *serviceContext) getMetadataComponentRoots(mdID int64) ([]int64, error) {
+	var cmpIDs []int64
+	err := svc.DB.Table("sirsi_metadata_components").Where("sirsi_metadata_id=?", mdID).Pluck("component_id", &cmpIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	var mfCmpIDs []int64
+	err = svc.DB.Table("master_files").Where("metadata_id=? and component_id > 0", mdID).Distinct().Pluck("component_id", &mfCmpIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	cmpIDs = append(cmpIDs, mfCmpIDs...)
+	out := make([]int64, 0)
+	if len(cmpIDs) == 0 {
+		return out, nil
+	}
+	var linked []component
+	err = svc.DB.Select("id", "ancestry").Where("id in ?", cmpIDs).Find(&linked).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, lc := range linked {
+		if slices.Contains(out, lc.rootID()) == false {
+			out = append(out, lc.rootID())
+		}
+	}
+	return out, nil
+}
+
+func (cmp *component) rootID() int64 {
+	if cmp.Ancestry == "" {
+		return cmp.ID
+	}
+	rootID, _ := strconv.ParseInt(strings.Split(cmp.Ancestry, "/")[0], 10, 64)
+	return rootID
+}
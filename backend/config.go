@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"time"
 )
 
 type dbConfig struct {
@@ -30,6 +31,13 @@ type configData struct {
 	pdfURL          string
 	solrURL         string
 	xmlIndexURL     string
+	oaiURL          string
+	oaiEmail        string
+	sirsiCacheTTL   time.Duration
+	sirsiCacheDB    bool
+	sirsiCacheMax   int
+	archiveDir      string
+	htPackageDir    string
 	devAuthUser     string
 	jwtKey          string
 }
@@ -49,6 +57,13 @@ func getConfiguration() *configData {
 	flag.StringVar(&config.solrURL, "solr", "http://virgo4-solr-production-replica-private.internal.lib.virginia.edu:8080/solr/test_core", "Solr URL")
 	flag.StringVar(&config.jobsURL, "jobs", "http://dockerprod1.lib.virginia.edu:8710", "URL for job processing")
 	flag.StringVar(&config.apolloURL, "apollo", "https://apollo.lib.virginia.edu", "URL for Apollo")
+	flag.DurationVar(&config.sirsiCacheTTL, "sirsicachettl", 24*time.Hour, "How long sirsi lookups are cached before solr is queried again")
+	flag.BoolVar(&config.sirsiCacheDB, "sirsicachedb", false, "Persist cached sirsi lookups in the DB")
+	flag.IntVar(&config.sirsiCacheMax, "sirsicachemax", 5000, "Maximum number of sirsi lookups held in memory")
+	flag.StringVar(&config.oaiURL, "oai", "https://tracksys.lib.virginia.edu/oai", "Public base URL of the OAI-PMH provider")
+	flag.StringVar(&config.oaiEmail, "oaiemail", "lib-dpg@virginia.edu", "OAI-PMH repository admin email")
+	flag.StringVar(&config.archiveDir, "archive", "", "Local path to the master file archive")
+	flag.StringVar(&config.htPackageDir, "htpackages", "", "Local path where HathiTrust submission packages are written")
 	flag.StringVar(&config.xmlIndexURL, "xmlhook", "https://virgo4-image-tracksys-reprocess-ws.internal.lib.virginia.edu/api/reindex", "XML index webhook")
 
 	// DB connection params
@@ -101,6 +116,13 @@ func getConfiguration() *configData {
 	log.Printf("[CONFIG] curio         = [%s]", config.curioURL)
 	log.Printf("[CONFIG] pdf           = [%s]", config.pdfURL)
 	log.Printf("[CONFIG] xmlhook       = [%s]", config.xmlIndexURL)
+	log.Printf("[CONFIG] oai           = [%s]", config.oaiURL)
+	log.Printf("[CONFIG] oaiemail      = [%s]", config.oaiEmail)
+	log.Printf("[CONFIG] sirsicachettl = [%s]", config.sirsiCacheTTL.String())
+	log.Printf("[CONFIG] sirsicachedb  = [%t]", config.sirsiCacheDB)
+	log.Printf("[CONFIG] sirsicachemax = [%d]", config.sirsiCacheMax)
+	log.Printf("[CONFIG] archive       = [%s]", config.archiveDir)
+	log.Printf("[CONFIG] htpackages    = [%s]", config.htPackageDir)
 	log.Printf("[CONFIG] dbuser        = [%s]", config.db.User)
 	log.Printf("[CONFIG] dbhost        = [%s]", config.db.Host)
 	log.Printf("[CONFIG] dbport        = [%d]", config.db.Port)
@@ -59,19 +59,18 @@ func (svc *serviceContext) getDashboardStats(c *gin.Context) {
 	}
 
 	// archivesspace
-	var asActive []archivesspaceReview
-	err = svc.DB.Where("published_at is null").Find(&asActive).Error
+	asCounts, err := svc.getArchivesSpaceStatusCounts()
 	if err != nil {
 		log.Printf("ERROR: unable to get active archivesspace review stats: %s", err.Error())
 	} else {
-		for _, asR := range asActive {
-			switch asR.Status {
-			case "rejected":
-				stats.ArchivesSpaceRejections++
-			case "requested":
-				stats.ArchivesSpaceRequests++
+		for status, cnt := range asCounts {
+			switch status {
+			case asRejected:
+				stats.ArchivesSpaceRejections += cnt
+			case asRequested:
+				stats.ArchivesSpaceRequests += cnt
 			default:
-				stats.ArchivesSpaceReviews++
+				stats.ArchivesSpaceReviews += cnt
 			}
 		}
 	}
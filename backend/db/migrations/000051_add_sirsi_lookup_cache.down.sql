@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sirsi_lookup_cache;
\ No newline at end of file
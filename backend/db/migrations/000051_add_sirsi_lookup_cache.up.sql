@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `sirsi_lookup_cache` (
+  `cache_key` varchar(255) NOT NULL,
+  `catalog_key` varchar(255) DEFAULT NULL,
+  `barcode` varchar(255) DEFAULT NULL,
+  `response` text NOT NULL,
+  `fetched_at` datetime NOT NULL,
+  PRIMARY KEY (`cache_key`),
+  KEY `index_sirsi_lookup_cache_on_catalog_key` (`catalog_key`),
+  KEY `index_sirsi_lookup_cache_on_barcode` (`barcode`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
\ No newline at end of file
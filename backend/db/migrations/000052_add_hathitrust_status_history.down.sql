@@ -0,0 +1 @@
+DROP TABLE IF EXISTS hathitrust_status_histories;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `hathitrust_status_histories` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `hathitrust_status_id` bigint NOT NULL,
+  `staff_member_id` bigint DEFAULT NULL,
+  `field` varchar(50) NOT NULL,
+  `old_value` varchar(255) DEFAULT NULL,
+  `new_value` varchar(255) DEFAULT NULL,
+  `source` varchar(50) NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_hathitrust_status_histories_on_status_id` (`hathitrust_status_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1 @@
+ALTER TABLE archivesspace_reviews DROP COLUMN `assigned_at`;
\ No newline at end of file
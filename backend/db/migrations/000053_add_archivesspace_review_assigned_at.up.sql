@@ -0,0 +1 @@
+ALTER TABLE archivesspace_reviews ADD COLUMN `assigned_at` datetime DEFAULT NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS review_comments;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `review_comments` (
+   `id` bigint NOT NULL AUTO_INCREMENT,
+   `archivesspace_review_id` bigint NOT NULL,
+   `staff_member_id` int DEFAULT NULL,
+   `action` varchar(20) NOT NULL,
+   `body` text,
+   `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_review_comments_on_archivesspace_review_id` (`archivesspace_review_id`),
+  CONSTRAINT `review_comments_archivesspace_review_id_fk` FOREIGN KEY (`archivesspace_review_id`) REFERENCES `archivesspace_reviews` (`id`),
+  CONSTRAINT `review_comments_staff_member_id_fk` FOREIGN KEY (`staff_member_id`) REFERENCES `staff_members` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
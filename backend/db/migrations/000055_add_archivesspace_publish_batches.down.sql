@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS archivesspace_publish_items;
+DROP TABLE IF EXISTS archivesspace_publish_batches;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS `archivesspace_publish_batches` (
+   `id` bigint NOT NULL AUTO_INCREMENT,
+   `staff_member_id` int DEFAULT NULL,
+   `status` varchar(20) NOT NULL,
+   `total` int NOT NULL DEFAULT 0,
+   `published` int NOT NULL DEFAULT 0,
+   `failed` int NOT NULL DEFAULT 0,
+   `skipped` int NOT NULL DEFAULT 0,
+   `created_at` datetime NOT NULL,
+   `finished_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  CONSTRAINT `archivesspace_publish_batches_staff_member_id_fk` FOREIGN KEY (`staff_member_id`) REFERENCES `staff_members` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `archivesspace_publish_items` (
+   `id` bigint NOT NULL AUTO_INCREMENT,
+   `batch_id` bigint NOT NULL,
+   `metadata_id` int NOT NULL,
+   `unit_id` int DEFAULT NULL,
+   `status` varchar(20) NOT NULL,
+   `message` text,
+   `updated_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `index_archivesspace_publish_items_on_batch_id` (`batch_id`),
+  CONSTRAINT `archivesspace_publish_items_batch_id_fk` FOREIGN KEY (`batch_id`) REFERENCES `archivesspace_publish_batches` (`id`),
+  CONSTRAINT `archivesspace_publish_items_metadata_id_fk` FOREIGN KEY (`metadata_id`) REFERENCES `metadata` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
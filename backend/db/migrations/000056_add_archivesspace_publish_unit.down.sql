@@ -0,0 +1,3 @@
+ALTER table archivesspace_reviews
+   DROP FOREIGN KEY `archivesspace_reviews_publish_unit_staff_id_fk`,
+   DROP COLUMN publish_unit_staff_id;
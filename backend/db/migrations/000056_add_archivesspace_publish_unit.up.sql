@@ -0,0 +1,3 @@
+ALTER table archivesspace_reviews
+   ADD COLUMN publish_unit_staff_id int DEFAULT null,
+   ADD CONSTRAINT `archivesspace_reviews_publish_unit_staff_id_fk` FOREIGN KEY (`publish_unit_staff_id`) REFERENCES `staff_members` (`id`);
@@ -0,0 +1 @@
+ALTER TABLE sirsi_lookup_cache DROP COLUMN marc_record;
@@ -0,0 +1 @@
+ALTER TABLE sirsi_lookup_cache ADD COLUMN marc_record mediumtext DEFAULT NULL;
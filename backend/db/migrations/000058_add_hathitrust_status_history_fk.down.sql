@@ -0,0 +1 @@
+ALTER TABLE hathitrust_status_histories DROP FOREIGN KEY `hathitrust_status_histories_status_id_fk`;
@@ -0,0 +1,5 @@
+DELETE FROM hathitrust_status_histories
+   WHERE hathitrust_status_id NOT IN (SELECT id FROM hathitrust_statuses);
+
+ALTER table hathitrust_status_histories
+   ADD CONSTRAINT `hathitrust_status_histories_status_id_fk` FOREIGN KEY (`hathitrust_status_id`) REFERENCES `hathitrust_statuses` (`id`);
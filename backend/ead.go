@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// eadDocument covers the parts of EAD 2002 and EAD3 finding aids that map to components. Element names
+// are matched without regard to namespace, so both versions parse into the same structure.
+type eadDocument struct {
+	XMLName  xml.Name     `xml:"ead"`
+	ArchDesc eadComponent `xml:"archdesc"`
+}
+
+type eadComponent struct {
+	XMLName      xml.Name
+	ID           string         `xml:"id,attr"`
+	Level        string         `xml:"level,attr"`
+	OtherLevel   string         `xml:"otherlevel,attr"`
+	Did          eadDid         `xml:"did"`
+	ScopeContent []eadText      `xml:"scopecontent"`
+	Dsc          *eadDsc        `xml:"dsc"`
+	Children     []eadComponent `xml:",any"`
+}
+
+type eadDsc struct {
+	Children []eadComponent `xml:",any"`
+}
+
+type eadDid struct {
+	UnitTitle []eadText      `xml:"unittitle"`
+	UnitDate  []eadText      `xml:"unitdate"`
+	UnitID    []eadUnitID    `xml:"unitid"`
+	Container []eadContainer `xml:"container"`
+}
+
+type eadText struct {
+	Value string `xml:",innerxml"`
+}
+
+type eadUnitID struct {
+	Type      string `xml:"type,attr"`
+	LocalType string `xml:"localtype,attr"`
+	Value     string `xml:",chardata"`
+}
+
+type eadContainer struct {
+	Type        string `xml:"type,attr"`
+	LocalType   string `xml:"localtype,attr"`
+	Label       string `xml:"label,attr"`
+	ContainerID string `xml:"containerid,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type eadImportResponse struct {
+	DryRun    bool       `json:"dryRun"`
+	Created   int        `json:"created"`
+	Component *component `json:"component"`
+	Warnings  []string   `json:"warnings"`
+}
+
+var eadComponentRegex = regexp.MustCompile(`^c(0[1-9]|1[0-2])?$`)
+var eadTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// importEADComponents builds a component tree from an EAD 2002 or EAD3 finding aid. The archdesc becomes
+// the top component and each c/c01-c12 becomes a child. Levels are matched to component types by name;
+// the defaultType param supplies the type for levels that have no match. With dryrun=true the tree
+// is returned without being saved.
+func (svc *serviceContext) importEADComponents(c *gin.Context) {
+	dryRun := c.Query("dryrun") == "true"
+	defaultTypeID, _ := strconv.ParseInt(c.Query("defaultType"), 10, 64)
+	var eadData []byte
+	formFile, err := c.FormFile("file")
+	if err == nil {
+		f, openErr := formFile.Open()
+		if openErr != nil {
+			log.Printf("ERROR: unable to open ead file %s: %s", formFile.Filename, openErr.Error())
+			c.String(http.StatusBadRequest, openErr.Error())
+			return
+		}
+		eadData, err = io.ReadAll(f)
+		f.Close()
+	} else {
+		eadData, err = io.ReadAll(c.Request.Body)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to read ead file: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var doc eadDocument
+	err = xml.Unmarshal(eadData, &doc)
+	if err != nil {
+		log.Printf("ERROR: unable to parse ead: %s", err.Error())
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid ead: %s", err.Error()))
+		return
+	}
+
+	var types []componentType
+	err = svc.DB.Find(&types).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component types: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	typeIDs := make(map[string]int64)
+	for _, ct := range types {
+		typeIDs[strings.ToLower(ct.Name)] = ct.ID
+	}
+	if defaultTypeID > 0 && svc.validComponentType(defaultTypeID) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid default component type %d", defaultTypeID))
+		return
+	}
+
+	out := eadImportResponse{DryRun: dryRun, Warnings: make([]string, 0)}
+	unmapped := make(map[string]bool)
+	var buildTree func(ec *eadComponent) *component
+	buildTree = func(ec *eadComponent) *component {
+		out.Created++
+		cmp := ec.toComponent(&out.Warnings)
+		typeID, ok := typeIDs[strings.ToLower(cmp.Level)]
+		if ok == false {
+			typeID = defaultTypeID
+			if defaultTypeID == 0 {
+				unmapped[cmp.Level] = true
+			}
+		}
+		cmp.ComponentTypeID = typeID
+		for _, child := range ec.children() {
+			cmp.Children = append(cmp.Children, buildTree(child))
+		}
+		return cmp
+	}
+	out.Component = buildTree(&doc.ArchDesc)
+
+	if len(unmapped) > 0 {
+		levels := make([]string, 0, len(unmapped))
+		for lvl := range unmapped {
+			levels = append(levels, fmt.Sprintf("[%s]", lvl))
+		}
+		log.Printf("INFO: ead import has levels without a component type: %v", levels)
+		c.String(http.StatusUnprocessableEntity, fmt.Sprintf("no component type matches levels %s; specify a defaultType", strings.Join(levels, ", ")))
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+
+	log.Printf("INFO: import %d components from ead [%s]", out.Created, out.Component.Title)
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		var create func(cmp *component, parent *component) error
+		create = func(cmp *component, parent *component) error {
+			if parent != nil {
+				cmp.ParentComponentID = parent.ID
+				cmp.Ancestry = parent.childAncestry()
+			}
+			if err := tx.Omit(clause.Associations).Create(cmp).Error; err != nil {
+				return fmt.Errorf("unable to create component [%s]: %s", cmp.Title, err.Error())
+			}
+			for _, child := range cmp.Children {
+				if err := create(child, cmp); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return create(out.Component, nil)
+	})
+	if err != nil {
+		log.Printf("ERROR: ead import failed: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: ead imported as component %d with %d components", out.Component.ID, out.Created)
+	c.JSON(http.StatusOK, out)
+}
+
+// children returns the nested c, c01-c12 components, whether directly nested or within a dsc
+func (ec *eadComponent) children() []*eadComponent {
+	out := make([]*eadComponent, 0)
+	kids := ec.Children
+	if ec.Dsc != nil {
+		kids = append(kids, ec.Dsc.Children...)
+	}
+	for idx := range kids {
+		if eadComponentRegex.MatchString(kids[idx].XMLName.Local) {
+			out = append(out, &kids[idx])
+		}
+	}
+	return out
+}
+
+func (ec *eadComponent) toComponent(warnings *[]string) *component {
+	cmp := component{EadIDAtt: ec.ID, Level: strings.ToLower(ec.Level), Children: make([]*component, 0)}
+	if cmp.Level == "otherlevel" && ec.OtherLevel != "" {
+		cmp.Level = strings.ToLower(ec.OtherLevel)
+	}
+	if len(ec.Did.UnitTitle) > 0 {
+		cmp.Title = eadTextValue(ec.Did.UnitTitle[0].Value)
+	}
+	if len(ec.Did.UnitDate) > 0 {
+		dates := make([]string, 0)
+		for _, d := range ec.Did.UnitDate {
+			dates = append(dates, eadTextValue(d.Value))
+		}
+		cmp.Date = strings.Join(dates, ", ")
+	}
+	if len(ec.ScopeContent) > 0 {
+		desc := make([]string, 0)
+		for _, sc := range ec.ScopeContent {
+			desc = append(desc, eadTextValue(sc.Value))
+		}
+		cmp.ContentDesc = strings.Join(desc, "\n")
+	}
+	for _, uid := range ec.Did.UnitID {
+		if strings.EqualFold(uid.Type, "barcode") || strings.EqualFold(uid.LocalType, "barcode") {
+			cmp.Barcode = strings.TrimSpace(uid.Value)
+		}
+	}
+
+	containers := make([]string, 0)
+	for _, ctr := range ec.Did.Container {
+		ctrType := ctr.Type
+		if ctrType == "" {
+			ctrType = ctr.LocalType
+		}
+		containers = append(containers, strings.TrimSpace(fmt.Sprintf("%s %s", ctrType, strings.TrimSpace(ctr.Value))))
+		if cmp.Barcode == "" && ctr.ContainerID != "" {
+			cmp.Barcode = ctr.ContainerID
+		}
+	}
+	cmp.Label = strings.Join(containers, ", ")
+	if cmp.Title == "" {
+		cmp.Title = cmp.Label
+	}
+
+	// components store these in varchar(255) columns
+	truncate := func(field string, val *string) {
+		if runes := []rune(*val); len(runes) > 255 {
+			*val = string(runes[:255])
+			*warnings = append(*warnings, fmt.Sprintf("%s of component [%s] truncated to 255 characters", field, cmp.Title))
+		}
+	}
+	truncate("title", &cmp.Title)
+	truncate("label", &cmp.Label)
+	truncate("date", &cmp.Date)
+	return &cmp
+}
+
+// eadTextValue strips markup such as emph and lb from mixed content and collapses whitespace
+func eadTextValue(innerXML string) string {
+	plain := eadTagRegex.ReplaceAllString(innerXML, " ")
+	var unescaped string
+	if err := xml.Unmarshal([]byte("<v>"+plain+"</v>"), &unescaped); err == nil {
+		plain = unescaped
+	}
+	return strings.Join(strings.Fields(plain), " ")
+}
+
+// ead3 output types; only the elements written by the component export are modeled
+type ead3Document struct {
+	XMLName  xml.Name      `xml:"ead"`
+	XMLNS    string        `xml:"xmlns,attr"`
+	Control  ead3Control   `xml:"control"`
+	ArchDesc ead3Component `xml:"archdesc"`
+}
+
+type ead3Control struct {
+	RecordID          string `xml:"recordid"`
+	TitleProper       string `xml:"filedesc>titlestmt>titleproper"`
+	MaintenanceStatus struct {
+		Value string `xml:"value,attr"`
+	} `xml:"maintenancestatus"`
+	AgencyName       string `xml:"maintenanceagency>agencyname"`
+	MaintenanceEvent struct {
+		EventType struct {
+			Value string `xml:"value,attr"`
+		} `xml:"eventtype"`
+		EventDateTime string `xml:"eventdatetime"`
+		AgentType     struct {
+			Value string `xml:"value,attr"`
+		} `xml:"agenttype"`
+		Agent string `xml:"agent"`
+	} `xml:"maintenancehistory>maintenanceevent"`
+}
+
+type ead3Component struct {
+	XMLName      xml.Name
+	ID           string           `xml:"id,attr,omitempty"`
+	Level        string           `xml:"level,attr,omitempty"`
+	OtherLevel   string           `xml:"otherlevel,attr,omitempty"`
+	Did          ead3Did          `xml:"did"`
+	ScopeContent *ead3Paragraphs  `xml:"scopecontent,omitempty"`
+	Dsc          *ead3Dsc         `xml:"dsc,omitempty"`
+	Children     []*ead3Component `xml:"c,omitempty"`
+}
+
+type ead3Paragraphs struct {
+	P []string `xml:"p"`
+}
+
+type ead3Dsc struct {
+	Children []*ead3Component `xml:"c"`
+}
+
+type ead3Did struct {
+	UnitID    []ead3UnitID `xml:"unitid,omitempty"`
+	UnitTitle string       `xml:"unittitle,omitempty"`
+	UnitDate  string       `xml:"unitdate,omitempty"`
+	DAO       []ead3DAO    `xml:"dao,omitempty"`
+}
+
+type ead3UnitID struct {
+	LocalType string `xml:"localtype,attr,omitempty"`
+	Value     string `xml:",chardata"`
+}
+
+type ead3DAO struct {
+	DAOType   string `xml:"daotype,attr"`
+	Href      string `xml:"href,attr"`
+	LinkTitle string `xml:"linktitle,attr,omitempty"`
+	LinkRole  string `xml:"linkrole,attr,omitempty"`
+}
+
+// ead3Levels are the values allowed in the EAD3 level attribute; anything else is written as otherlevel
+var ead3Levels = []string{"collection", "fonds", "class", "recordgrp", "series", "subfonds", "subgrp", "subseries", "file", "item"}
+
+// exportComponentEAD serializes a component and everything below it as an EAD3 finding aid. Master files
+// attached to a component are included as dao links to the Curio viewer, or to IIIF if they have no metadata.
+func (svc *serviceContext) exportComponentEAD(c *gin.Context) {
+	cID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	log.Printf("INFO: export component %d as ead", cID)
+	var top component
+	err := svc.DB.Limit(1).Find(&top, cID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if top.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("%d not found", cID))
+		return
+	}
+	err = svc.loadComponentChildren(&top)
+	if err != nil {
+		log.Printf("ERROR: unable to get children of %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cmpIDs := make([]int64, 0)
+	var collectIDs func(cmp *component)
+	collectIDs = func(cmp *component) {
+		cmpIDs = append(cmpIDs, cmp.ID)
+		for _, child := range cmp.Children {
+			collectIDs(child)
+		}
+	}
+	collectIDs(&top)
+
+	var masterFiles []*masterFile
+	err = svc.DB.Preload("Metadata").Where("component_id in ?", cmpIDs).Order("filename asc").Find(&masterFiles).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get master files for component tree %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	daos := make(map[int64][]ead3DAO)
+	cmpIdx := make(map[int64]int)
+	for _, mf := range masterFiles {
+		iiifURL := svc.getComponentViewerURL(mf, cmpIdx[mf.ComponentID])
+		cmpIdx[mf.ComponentID]++
+		dao := ead3DAO{DAOType: "derived", Href: iiifURL, LinkTitle: mf.Title, LinkRole: "image/jpeg"}
+		if mf.ViewerURL != "" {
+			dao.Href = mf.ViewerURL
+			dao.LinkRole = "text/html"
+		}
+		if dao.LinkTitle == "" {
+			dao.LinkTitle = mf.Filename
+		}
+		daos[mf.ComponentID] = append(daos[mf.ComponentID], dao)
+	}
+
+	doc := ead3Document{XMLNS: "http://ead3.archivists.org/schema/"}
+	doc.Control.RecordID = top.PID
+	doc.Control.TitleProper = top.Title
+	doc.Control.MaintenanceStatus.Value = "derived"
+	doc.Control.AgencyName = "University of Virginia Library"
+	doc.Control.MaintenanceEvent.EventType.Value = "derived"
+	doc.Control.MaintenanceEvent.EventDateTime = time.Now().Format(time.RFC3339)
+	doc.Control.MaintenanceEvent.AgentType.Value = "machine"
+	doc.Control.MaintenanceEvent.Agent = "TrackSys"
+	doc.ArchDesc = *toEAD3Component(&top, daos, true)
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf("ERROR: unable to generate ead for component %d: %s", cID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.xml", strings.ReplaceAll(top.PID, ":", "_")))
+	c.Data(http.StatusOK, "application/xml", append([]byte(xml.Header), out...))
+}
+
+func toEAD3Component(cmp *component, daos map[int64][]ead3DAO, isArchDesc bool) *ead3Component {
+	out := ead3Component{XMLName: xml.Name{Local: "c"}, ID: cmp.EadIDAtt, Level: strings.ToLower(strings.TrimSpace(cmp.Level))}
+	if isArchDesc {
+		out.XMLName.Local = "archdesc"
+		if out.Level == "" {
+			out.Level = "collection"
+		}
+	}
+	if out.Level != "" && slices.Contains(ead3Levels, out.Level) == false {
+		out.OtherLevel = cmp.Level
+		out.Level = "otherlevel"
+	}
+
+	out.Did.UnitTitle = cmp.Title
+	out.Did.UnitDate = cmp.Date
+	if cmp.Label != "" {
+		out.Did.UnitID = append(out.Did.UnitID, ead3UnitID{LocalType: "label", Value: cmp.Label})
+	}
+	if cmp.Barcode != "" {
+		out.Did.UnitID = append(out.Did.UnitID, ead3UnitID{LocalType: "barcode", Value: cmp.Barcode})
+	}
+	if out.Did.UnitTitle == "" && out.Did.UnitDate == "" && len(out.Did.UnitID) == 0 {
+		// did requires at least one descriptive element
+		out.Did.UnitID = append(out.Did.UnitID, ead3UnitID{LocalType: "pid", Value: cmp.PID})
+	}
+	out.Did.DAO = daos[cmp.ID]
+	if cmp.ContentDesc != "" {
+		out.ScopeContent = &ead3Paragraphs{P: strings.Split(cmp.ContentDesc, "\n")}
+	}
+
+	children := make([]*ead3Component, 0)
+	for _, child := range cmp.Children {
+		children = append(children, toEAD3Component(child, daos, false))
+	}
+	if len(children) > 0 {
+		if isArchDesc {
+			out.Dsc = &ead3Dsc{Children: children}
+		} else {
+			out.Children = children
+		}
+	}
+	return &out
+}
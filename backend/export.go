@@ -0,0 +1,705 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportRecord is the common crosswalk of a Sirsi, XML or ArchivesSpace metadata record. Each export
+// format is generated from this, with the original MARC or MODS used as the base when available.
+type exportRecord struct {
+	PID                string
+	Type               string
+	Title              string
+	CreatorName        string
+	CreatorType        string
+	Date               string
+	Place              string
+	Subjects           []string
+	Genres             []string
+	Extent             string
+	Notes              []string
+	Language           string
+	CallNumber         string
+	Barcode            string
+	CatalogKey         string
+	URL                string
+	CollectionID       string
+	CollectionTitle    string
+	UseRightName       string
+	UseRightURI        string
+	UseRightStatement  string
+	AvailabilityPolicy string
+	marc               *marcRecord
+	mods               *modsDocument
+}
+
+type exportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var exportFormats = map[string]exportFormat{
+	"marcxml": {ContentType: "application/marcxml+xml", Extension: "xml"},
+	"mods":    {ContentType: "application/mods+xml", Extension: "xml"},
+	"dc":      {ContentType: "application/xml", Extension: "xml"},
+	"jsonld":  {ContentType: "application/ld+json", Extension: "jsonld"},
+}
+
+type marcCollection struct {
+	XMLName xml.Name `xml:"http://www.loc.gov/MARC21/slim collection"`
+	Records []marcRecord
+}
+
+// dublinCore is an oai_dc record. Element names include the prefix so the output uses the standard oai_dc form.
+type dublinCore struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOAI       string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXSI       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Subject        []string `xml:"dc:subject"`
+	Description    []string `xml:"dc:description"`
+	Publisher      []string `xml:"dc:publisher"`
+	Date           []string `xml:"dc:date"`
+	Type           []string `xml:"dc:type"`
+	Format         []string `xml:"dc:format"`
+	Identifier     []string `xml:"dc:identifier"`
+	Language       []string `xml:"dc:language"`
+	Relation       []string `xml:"dc:relation"`
+	Rights         []string `xml:"dc:rights"`
+}
+
+const exportMODSTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<mods xmlns="http://www.loc.gov/mods/v3" xmlns:xlink="http://www.w3.org/1999/xlink" version="3.7">
+</mods>`
+
+func (svc *serviceContext) exportMetadata(c *gin.Context) {
+	format := strings.ToLower(c.Query("format"))
+	fmtInfo, ok := exportFormats[format]
+	if ok == false {
+		log.Printf("ERROR: unsupported metadata export format [%s]", format)
+		c.String(http.StatusBadRequest, fmt.Sprintf("unsupported format [%s]; use marcxml, mods, dc or jsonld", format))
+		return
+	}
+	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if mdID == 0 {
+		log.Printf("ERROR: invalid metadata id %s for export", c.Param("id"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid id %s", c.Param("id")))
+		return
+	}
+
+	log.Printf("INFO: export metadata %d as %s", mdID, format)
+	var md metadata
+	err := svc.DB.Preload("AvailabilityPolicy").Preload("UseRight").Preload("ExternalSystem").Limit(1).Find(&md, mdID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load metadata %d for export: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if md.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("metadata %d not found", mdID))
+		return
+	}
+
+	out, err := svc.exportMetadataRecord(&md, format)
+	if err != nil {
+		log.Printf("ERROR: unable to export metadata %d as %s: %s", mdID, format, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, fmtInfo.ContentType, out)
+}
+
+func (svc *serviceContext) exportCollection(c *gin.Context) {
+	format := strings.ToLower(c.Query("format"))
+	fmtInfo, ok := exportFormats[format]
+	if ok == false {
+		log.Printf("ERROR: unsupported collection export format [%s]", format)
+		c.String(http.StatusBadRequest, fmt.Sprintf("unsupported format [%s]; use marcxml, mods, dc or jsonld", format))
+		return
+	}
+	collectionID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if collectionID == 0 {
+		log.Printf("ERROR: invalid collection id %s for export", c.Param("id"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid collection id %s", c.Param("id")))
+		return
+	}
+
+	var collection metadata
+	err := svc.DB.Preload("AvailabilityPolicy").Preload("UseRight").Preload("ExternalSystem").Limit(1).Find(&collection, collectionID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load collection %d for export: %s", collectionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if collection.ID == 0 || collection.IsCollection == false {
+		c.String(http.StatusNotFound, fmt.Sprintf("collection %d not found", collectionID))
+		return
+	}
+
+	var items []metadata
+	err = svc.DB.Preload("AvailabilityPolicy").Preload("UseRight").Preload("ExternalSystem").Where("parent_metadata_id=?", collectionID).Order("id asc").Find(&items).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load collection %d items for export: %s", collectionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("INFO: export collection %d and %d items as %s", collectionID, len(items), format)
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.zip", exportFileName(collection.PID), format))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	var failures []string
+	all := append([]metadata{collection}, items...)
+	for idx := range all {
+		md := &all[idx]
+		out, err := svc.exportMetadataRecord(md, format)
+		if err != nil {
+			log.Printf("ERROR: unable to export %s as %s: %s", md.PID, format, err.Error())
+			failures = append(failures, fmt.Sprintf("%s: %s", md.PID, err.Error()))
+			continue
+		}
+		zf, err := zw.Create(fmt.Sprintf("%s.%s", exportFileName(md.PID), fmtInfo.Extension))
+		if err != nil {
+			log.Printf("ERROR: unable to add %s to export zip: %s", md.PID, err.Error())
+			break
+		}
+		zf.Write(out)
+	}
+	if len(failures) > 0 {
+		zf, err := zw.Create("errors.txt")
+		if err == nil {
+			zf.Write([]byte(strings.Join(failures, "\n")))
+		}
+	}
+	zw.Close()
+	log.Printf("INFO: collection %d export complete with %d failures", collectionID, len(failures))
+}
+
+func exportFileName(pid string) string {
+	return strings.ReplaceAll(pid, ":", "_")
+}
+
+func (svc *serviceContext) exportMetadataRecord(md *metadata, format string) ([]byte, error) {
+	rec, err := svc.buildExportRecord(md)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "marcxml":
+		return rec.toMARCXML()
+	case "mods":
+		return rec.toMODS()
+	case "dc":
+		return rec.toDublinCore()
+	case "jsonld":
+		return rec.toJSONLD()
+	}
+	return nil, fmt.Errorf("unsupported format %s", format)
+}
+
+func (svc *serviceContext) buildExportRecord(md *metadata) (*exportRecord, error) {
+	rec := exportRecord{PID: md.PID, Type: md.Type, Title: md.Title}
+	if md.CreatorName != nil {
+		rec.CreatorName = *md.CreatorName
+	}
+	if md.CallNumber != nil {
+		rec.CallNumber = *md.CallNumber
+	}
+	if md.Barcode != nil {
+		rec.Barcode = *md.Barcode
+	}
+	if md.CatalogKey != nil {
+		rec.CatalogKey = *md.CatalogKey
+	}
+	if md.CollectionID != nil {
+		rec.CollectionID = *md.CollectionID
+	}
+	if md.AvailabilityPolicy != nil {
+		rec.AvailabilityPolicy = md.AvailabilityPolicy.Name
+	}
+	if md.UseRight != nil {
+		rec.UseRightName = md.UseRight.Name
+		rec.UseRightURI = md.UseRight.URI
+		rec.UseRightStatement = md.UseRight.Statement
+	}
+	if md.ParentMetadataID > 0 {
+		var parent metadata
+		err := svc.DB.Select("id", "title").Limit(1).Find(&parent, md.ParentMetadataID).Error
+		if err != nil {
+			log.Printf("WARNING: unable to get parent collection %d for %s: %s", md.ParentMetadataID, md.PID, err.Error())
+		} else {
+			rec.CollectionTitle = parent.Title
+		}
+	}
+
+	switch md.Type {
+	case "SirsiMetadata":
+		rec.URL = fmt.Sprintf("%s/sources/uva_library/items/%s", svc.ExternalSystems.Virgo, rec.CatalogKey)
+		marc, sirsiResp, err := svc.cachedSirsiMARC(rec.CatalogKey, rec.Barcode)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get sirsi marc: %s", err.Error())
+		}
+		rec.addMARC(marc, sirsiResp)
+	case "XmlMetadata":
+		rec.URL = fmt.Sprintf("%s/sources/images/items/%s", svc.ExternalSystems.Virgo, md.PID)
+		if md.DescMetadata == nil {
+			return nil, fmt.Errorf("xml metadata %s has no MODS", md.PID)
+		}
+		doc, err := parseMODSDocument(*md.DescMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse MODS: %s", err.Error())
+		}
+		rec.addMODS(doc)
+	case "ExternalMetadata":
+		// only ArchivesSpace has a metadata lookup; other external records are exported from the stored fields
+		if md.ExternalURI == nil || md.ExternalSystem == nil {
+			break
+		}
+		if md.ExternalSystem.Name != "ArchivesSpace" {
+			if md.ExternalSystem.PublicURL != "" {
+				rec.URL = fmt.Sprintf("%s%s", md.ExternalSystem.PublicURL, *md.ExternalURI)
+			}
+			break
+		}
+		asData, err := svc.getArchivesSpaceMetadata(md)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get ArchivesSpace metadata: %s", err.Error())
+		}
+		rec.Title = asData.Title
+		rec.Date = asData.Dates
+		rec.Language = asData.Language
+		rec.URL = asData.URL
+		if asData.CollectionTitle != "" {
+			rec.CollectionTitle = asData.CollectionTitle
+		}
+		if asData.CollectionID != "" {
+			rec.CollectionID = asData.CollectionID
+			if rec.CallNumber == "" {
+				rec.CallNumber = asData.CollectionID
+			}
+		}
+	}
+	return &rec, nil
+}
+
+func (rec *exportRecord) addMARC(marc *marcRecord, sirsi *sirsiResponse) {
+	rec.marc = marc
+	rec.Title = sirsi.Title
+	rec.CreatorName = sirsi.CreatorName
+	rec.CreatorType = sirsi.CreatorType
+	rec.Date = sirsi.Year
+	rec.Place = sirsi.PublicationPlace
+	if sirsi.CallNumber != "" {
+		rec.CallNumber = sirsi.CallNumber
+	}
+	if sirsi.CollectionID != "" {
+		rec.CollectionID = sirsi.CollectionID
+	}
+	if sirsi.UseRightName != "" {
+		rec.UseRightName = sirsi.UseRightName
+		rec.UseRightURI = sirsi.UseRightURI
+		rec.UseRightStatement = sirsi.UseRightStatement
+	}
+	for _, cf := range marc.ControlFields {
+		if cf.Tag == "008" && len(cf.Value) >= 38 {
+			rec.Language = strings.TrimSpace(cf.Value[35:38])
+		}
+	}
+	for _, df := range marc.DataFields {
+		switch df.Tag {
+		case "300":
+			rec.Extent = strings.TrimSpace(strings.Join(df.subfieldValues("a", "b", "c"), " "))
+		case "500", "520":
+			rec.Notes = append(rec.Notes, df.subfieldValues("a")...)
+		case "600", "610", "650", "651":
+			if subj := strings.Join(df.subfieldValues("a", "x", "y", "z"), " -- "); subj != "" {
+				rec.Subjects = append(rec.Subjects, strings.TrimSuffix(subj, "."))
+			}
+		case "655":
+			rec.Genres = append(rec.Genres, df.subfieldValues("a")...)
+		}
+	}
+}
+
+func (df *dataField) subfieldValues(codes ...string) []string {
+	out := make([]string, 0)
+	for _, sf := range df.Subfields {
+		for _, code := range codes {
+			if sf.Code == code && strings.TrimSpace(sf.Value) != "" {
+				out = append(out, strings.TrimSpace(sf.Value))
+			}
+		}
+	}
+	return out
+}
+
+func (rec *exportRecord) addMODS(doc *modsDocument) {
+	rec.mods = doc
+	f := doc.fields()
+	if len(f.Titles) > 0 {
+		rec.Title = strings.TrimSpace(strings.Join([]string{f.Titles[0].NonSort, f.Titles[0].Title}, " "))
+	}
+	if len(f.Names) > 0 {
+		parts := make([]string, 0)
+		for _, np := range f.Names[0].NameParts {
+			parts = append(parts, np.Value)
+		}
+		rec.CreatorName = strings.Join(parts, ", ")
+		rec.CreatorType = f.Names[0].Type
+	}
+	for _, d := range f.Dates {
+		if rec.Date == "" || d.KeyDate {
+			rec.Date = d.Value
+		}
+	}
+	for _, s := range f.Subjects {
+		terms := make([]string, 0)
+		for _, t := range s.Terms {
+			terms = append(terms, t.Value)
+		}
+		if len(terms) > 0 {
+			rec.Subjects = append(rec.Subjects, strings.Join(terms, " -- "))
+		}
+	}
+	for _, g := range f.Genres {
+		rec.Genres = append(rec.Genres, g.Value)
+	}
+	if len(f.PhysicalDescription.Extents) > 0 {
+		rec.Extent = f.PhysicalDescription.Extents[0].Value
+	}
+	for _, n := range f.Notes {
+		rec.Notes = append(rec.Notes, n.Value)
+	}
+	if rec.UseRightName == "" {
+		for _, r := range f.Rights {
+			if r.Type == "" || r.Type == "use and reproduction" {
+				rec.UseRightStatement = r.Value
+				break
+			}
+		}
+	}
+	if lang := doc.Root.elements("language"); len(lang) > 0 {
+		rec.Language = lang[0].firstText("languageTerm")
+	}
+}
+
+func newDataField(tag, ind1, ind2 string, subfields ...string) dataField {
+	df := dataField{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for i := 0; i+1 < len(subfields); i += 2 {
+		if subfields[i+1] != "" {
+			df.Subfields = append(df.Subfields, subField{Code: subfields[i], Value: subfields[i+1]})
+		}
+	}
+	return df
+}
+
+func (rec *exportRecord) rightsDataFields() []dataField {
+	out := make([]dataField, 0)
+	if rec.AvailabilityPolicy != "" {
+		out = append(out, newDataField("506", " ", " ", "a", rec.AvailabilityPolicy))
+	}
+	if rec.UseRightName != "" || rec.UseRightStatement != "" {
+		out = append(out, newDataField("540", " ", " ", "a", rec.UseRightStatement, "f", rec.UseRightName, "u", rec.UseRightURI))
+	}
+	return out
+}
+
+func (rec *exportRecord) toMARCXML() ([]byte, error) {
+	out := marcRecord{}
+	if rec.marc != nil {
+		// keep the catalog record as-is, replacing only the access and use statements
+		out.Leader = rec.marc.Leader
+		out.ControlFields = rec.marc.ControlFields
+		for _, df := range rec.marc.DataFields {
+			if df.Tag == "506" || df.Tag == "540" {
+				continue
+			}
+			df.Value = ""
+			out.DataFields = append(out.DataFields, df)
+		}
+	} else {
+		out.Leader = "00000nkm a2200000 i 4500"
+		out.ControlFields = append(out.ControlFields, controlField{Tag: "001", Value: rec.PID})
+		if rec.CreatorName != "" {
+			tag := "100"
+			if rec.CreatorType == "corporate" {
+				tag = "110"
+			}
+			out.DataFields = append(out.DataFields, newDataField(tag, "1", " ", "a", rec.CreatorName))
+		}
+		out.DataFields = append(out.DataFields, newDataField("245", "0", "0", "a", rec.Title))
+		if rec.Place != "" || rec.Date != "" {
+			out.DataFields = append(out.DataFields, newDataField("264", " ", "0", "a", rec.Place, "c", rec.Date))
+		}
+		if rec.Extent != "" {
+			out.DataFields = append(out.DataFields, newDataField("300", " ", " ", "a", rec.Extent))
+		}
+		for _, n := range rec.Notes {
+			out.DataFields = append(out.DataFields, newDataField("500", " ", " ", "a", n))
+		}
+		for _, s := range rec.Subjects {
+			out.DataFields = append(out.DataFields, newDataField("650", " ", "4", "a", s))
+		}
+		for _, g := range rec.Genres {
+			out.DataFields = append(out.DataFields, newDataField("655", " ", "4", "a", g))
+		}
+		if rec.CollectionTitle != "" {
+			out.DataFields = append(out.DataFields, newDataField("773", "0", " ", "t", rec.CollectionTitle, "o", rec.CollectionID))
+		}
+		if rec.URL != "" {
+			out.DataFields = append(out.DataFields, newDataField("856", "4", "0", "u", rec.URL))
+		}
+	}
+	out.DataFields = append(out.DataFields, rec.rightsDataFields()...)
+	return marshalExportXML(marcCollection{Records: []marcRecord{out}})
+}
+
+func (rec *exportRecord) toMODS() ([]byte, error) {
+	doc, err := rec.modsDocument()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(doc.String()), nil
+}
+
+func (rec *exportRecord) modsDocument() (*modsDocument, error) {
+	doc := rec.mods
+	if doc == nil {
+		var err error
+		doc, err = parseMODSDocument(exportMODSTemplate)
+		if err != nil {
+			return nil, err
+		}
+		doc.applyFields(rec.modsFields())
+		extras := make([]*modsNode, 0)
+		if rec.Language != "" {
+			lang := doc.newElement("language")
+			lang.Children = append(lang.Children, doc.newTextElement("languageTerm", rec.Language))
+			extras = append(extras, lang)
+		}
+		extras = append(extras, rec.modsIdentifiers(doc)...)
+		if rec.URL != "" {
+			loc := doc.newElement("location")
+			loc.Children = append(loc.Children, doc.newTextElement("url", rec.URL))
+			extras = append(extras, loc)
+		}
+		if rec.CollectionTitle != "" {
+			related := doc.newElement("relatedItem")
+			related.setAttr("type", "host")
+			titleInfo := doc.newElement("titleInfo")
+			titleInfo.Children = append(titleInfo.Children, doc.newTextElement("title", rec.CollectionTitle))
+			related.Children = append(related.Children, titleInfo)
+			extras = append(extras, related)
+		}
+		doc.Root.replaceChildren(func(*modsNode) bool { return false }, extras, 1)
+	} else {
+		doc = &modsDocument{Prolog: doc.Prolog, Root: doc.Root.clone()}
+	}
+
+	// access conditions managed by tracksys replace any in the source document
+	conditions := make([]*modsNode, 0)
+	if rec.UseRightName != "" || (rec.mods == nil && rec.UseRightStatement != "") {
+		useCond := doc.newTextElement("accessCondition", strings.TrimSpace(fmt.Sprintf("%s %s", rec.UseRightName, rec.UseRightStatement)))
+		useCond.setAttr("type", "use and reproduction")
+		if rec.UseRightURI != "" {
+			useCond.setAttr("xlink:href", rec.UseRightURI)
+		}
+		conditions = append(conditions, useCond)
+	}
+	if rec.AvailabilityPolicy != "" {
+		availCond := doc.newTextElement("accessCondition", rec.AvailabilityPolicy)
+		availCond.setAttr("type", "restriction on access")
+		conditions = append(conditions, availCond)
+	}
+	if len(conditions) > 0 {
+		doc.Root.replaceChildren(func(n *modsNode) bool {
+			if n.Name.Local != "accessCondition" {
+				return false
+			}
+			condType := n.attr("type")
+			return (condType == "use and reproduction" && rec.UseRightName != "") ||
+				(condType == "restriction on access" && rec.AvailabilityPolicy != "")
+		}, conditions, 1)
+	}
+	if rec.UseRightURI != "" {
+		hasXlink := false
+		for _, a := range doc.Root.Attrs {
+			if a.Name.Space == "xmlns" && a.Name.Local == "xlink" {
+				hasXlink = true
+			}
+		}
+		if hasXlink == false {
+			doc.Root.Attrs = append(doc.Root.Attrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: "xlink"}, Value: "http://www.w3.org/1999/xlink"})
+		}
+	}
+	return doc, nil
+}
+
+func (rec *exportRecord) modsFields() *modsFields {
+	f := modsFields{}
+	f.Titles = []modsTitle{{Title: rec.Title}}
+	if rec.CreatorName != "" {
+		f.Names = []modsName{{Type: rec.CreatorType, NameParts: []modsNamePart{{Value: rec.CreatorName}},
+			Roles: []modsRole{{Value: "creator", Type: "text", Authority: "marcrelator"}}}}
+	}
+	if rec.Date != "" {
+		f.Dates = []modsDate{{Element: "dateCreated", Value: rec.Date, KeyDate: true}}
+	}
+	for _, s := range rec.Subjects {
+		f.Subjects = append(f.Subjects, modsSubject{Terms: []modsSubjectTerm{{Type: "topic", Value: s}}})
+	}
+	for _, g := range rec.Genres {
+		f.Genres = append(f.Genres, modsValue{Value: g})
+	}
+	if rec.Extent != "" {
+		f.PhysicalDescription.Extents = []modsValue{{Value: rec.Extent}}
+	}
+	for _, n := range rec.Notes {
+		f.Notes = append(f.Notes, modsValue{Value: n})
+	}
+	return &f
+}
+
+func (rec *exportRecord) modsIdentifiers(doc *modsDocument) []*modsNode {
+	out := make([]*modsNode, 0)
+	ids := [][]string{{"local", rec.PID}, {"Call Number", rec.CallNumber}, {"Catalog Key", rec.CatalogKey}, {"Barcode", rec.Barcode}}
+	for _, id := range ids {
+		if id[1] == "" {
+			continue
+		}
+		el := doc.newTextElement("identifier", id[1])
+		el.setAttr("type", id[0])
+		out = append(out, el)
+	}
+	return out
+}
+
+func (rec *exportRecord) dublinCore() *dublinCore {
+	dc := dublinCore{
+		XmlnsOAI:       "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          []string{rec.Title},
+		Subject:        rec.Subjects,
+		Description:    rec.Notes,
+		Type:           rec.Genres,
+		Identifier:     []string{rec.PID},
+	}
+	if rec.CreatorName != "" {
+		dc.Creator = append(dc.Creator, rec.CreatorName)
+	}
+	if rec.Place != "" {
+		dc.Publisher = append(dc.Publisher, rec.Place)
+	}
+	if rec.Date != "" {
+		dc.Date = append(dc.Date, rec.Date)
+	}
+	if rec.Extent != "" {
+		dc.Format = append(dc.Format, rec.Extent)
+	}
+	if rec.Language != "" {
+		dc.Language = append(dc.Language, rec.Language)
+	}
+	if rec.CallNumber != "" {
+		dc.Identifier = append(dc.Identifier, rec.CallNumber)
+	}
+	if rec.URL != "" {
+		dc.Identifier = append(dc.Identifier, rec.URL)
+	}
+	if rec.CollectionTitle != "" {
+		dc.Relation = append(dc.Relation, rec.CollectionTitle)
+	}
+	for _, r := range []string{rec.UseRightName, rec.UseRightStatement, rec.UseRightURI, rec.AvailabilityPolicy} {
+		if r != "" {
+			dc.Rights = append(dc.Rights, r)
+		}
+	}
+	return &dc
+}
+
+func (rec *exportRecord) toDublinCore() ([]byte, error) {
+	return marshalExportXML(rec.dublinCore())
+}
+
+func (rec *exportRecord) toJSONLD() ([]byte, error) {
+	out := map[string]any{
+		"@context":   "https://schema.org",
+		"@type":      "CreativeWork",
+		"@id":        rec.PID,
+		"identifier": rec.PID,
+		"name":       rec.Title,
+	}
+	if rec.Type == "ExternalMetadata" {
+		out["@type"] = "ArchiveComponent"
+	}
+	if rec.CreatorName != "" {
+		creatorType := "Person"
+		if rec.CreatorType == "corporate" {
+			creatorType = "Organization"
+		}
+		out["creator"] = map[string]string{"@type": creatorType, "name": rec.CreatorName}
+	}
+	if rec.Date != "" {
+		out["dateCreated"] = rec.Date
+	}
+	if rec.Place != "" {
+		out["locationCreated"] = rec.Place
+	}
+	if len(rec.Subjects) > 0 {
+		out["about"] = rec.Subjects
+	}
+	if len(rec.Genres) > 0 {
+		out["genre"] = rec.Genres
+	}
+	if len(rec.Notes) > 0 {
+		out["description"] = strings.Join(rec.Notes, "\n")
+	}
+	if rec.Extent != "" {
+		out["materialExtent"] = rec.Extent
+	}
+	if rec.Language != "" {
+		out["inLanguage"] = rec.Language
+	}
+	if rec.URL != "" {
+		out["url"] = rec.URL
+	}
+	if rec.CallNumber != "" {
+		out["sku"] = rec.CallNumber
+	}
+	if rec.CollectionTitle != "" {
+		out["isPartOf"] = map[string]string{"@type": "Collection", "name": rec.CollectionTitle, "identifier": rec.CollectionID}
+	}
+	if rec.UseRightURI != "" {
+		out["license"] = rec.UseRightURI
+	}
+	if rec.UseRightName != "" || rec.UseRightStatement != "" {
+		out["usageInfo"] = strings.TrimSpace(fmt.Sprintf("%s %s", rec.UseRightName, rec.UseRightStatement))
+	}
+	if rec.AvailabilityPolicy != "" {
+		out["conditionsOfAccess"] = rec.AvailabilityPolicy
+	}
+	return json.MarshalIndent(out, "", "   ")
+}
+
+func marshalExportXML(v any) ([]byte, error) {
+	raw, err := xml.MarshalIndent(v, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), raw...), nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type collectionFacetUsage struct {
+	ID         uint64 `json:"id"`
+	Name       string `json:"name"`
+	UsageCount int64  `json:"usageCount"`
+}
+
+// getCollectionFacets lists all collection facets with the number of metadata records that use each one
+func (svc *serviceContext) getCollectionFacets(c *gin.Context) {
+	log.Printf("INFO: get collection facets with usage counts")
+	var out []collectionFacetUsage
+	err := svc.DB.Table("collection_facets cf").
+		Joins("left join metadata m on m.collection_facet = cf.name").
+		Select("cf.id", "cf.name", "count(m.id) as usage_count").
+		Group("cf.id").Order("cf.name asc").Find(&out).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get collection facet usage: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// renameCollectionFacet renames a facet and all metadata records that use it. When reindex is set,
+// published XML metadata with the facet is sent to the DL reindex hook by a tracked job.
+func (svc *serviceContext) renameCollectionFacet(c *gin.Context) {
+	var req struct {
+		Name    string `json:"name"`
+		Reindex bool   `json:"reindex"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid rename collection facet request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.String(http.StatusBadRequest, "name is required")
+		return
+	}
+
+	facet, ok := svc.loadCollectionFacet(c, c.Param("id"))
+	if ok == false {
+		return
+	}
+	oldName := facet.Name
+	if oldName == req.Name {
+		c.String(http.StatusBadRequest, fmt.Sprintf("facet is already named %s", req.Name))
+		return
+	}
+
+	var dupCnt int64
+	svc.DB.Model(&collectionFacet{}).Where("name=? and id<>?", req.Name, facet.ID).Count(&dupCnt)
+	if dupCnt > 0 {
+		log.Printf("INFO: unable to rename facet %s; %s already exists", oldName, req.Name)
+		c.String(http.StatusConflict, fmt.Sprintf("facet %s already exists; merge instead", req.Name))
+		return
+	}
+
+	log.Printf("INFO: rename collection facet %s to %s", oldName, req.Name)
+	var updated int64
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		facet.Name = req.Name
+		facet.UpdatedAt = time.Now()
+		if err := tx.Model(facet).Select("Name", "UpdatedAt").Updates(facet).Error; err != nil {
+			return err
+		}
+		resp := tx.Exec("update metadata set collection_facet=? where collection_facet=?", req.Name, oldName)
+		updated = resp.RowsAffected
+		return resp.Error
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to rename collection facet %s to %s: %s", oldName, req.Name, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: facet %s renamed to %s; %d metadata records updated", oldName, req.Name, updated)
+
+	out := gin.H{"facet": collectionFacetUsage{ID: facet.ID, Name: facet.Name, UsageCount: updated}}
+	if req.Reindex && updated > 0 {
+		js, err := svc.reindexCollectionFacet(c, req.Name)
+		if err != nil {
+			log.Printf("ERROR: unable to start reindex for facet %s: %s", req.Name, err.Error())
+			c.String(http.StatusInternalServerError, fmt.Sprintf("facet renamed but reindex failed to start: %s", err.Error()))
+			return
+		}
+		out["jobID"] = js.ID
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// mergeCollectionFacet moves all metadata records from one facet to another, then deletes the source facet
+func (svc *serviceContext) mergeCollectionFacet(c *gin.Context) {
+	src, ok := svc.loadCollectionFacet(c, c.Param("id"))
+	if ok == false {
+		return
+	}
+	tgt, ok := svc.loadCollectionFacet(c, c.Query("into"))
+	if ok == false {
+		return
+	}
+	if src.ID == tgt.ID {
+		c.String(http.StatusBadRequest, "a facet cannot be merged into itself")
+		return
+	}
+
+	log.Printf("INFO: merge collection facet %s into %s", src.Name, tgt.Name)
+	var updated int64
+	err := svc.DB.Transaction(func(tx *gorm.DB) error {
+		resp := tx.Exec("update metadata set collection_facet=? where collection_facet=?", tgt.Name, src.Name)
+		if resp.Error != nil {
+			return resp.Error
+		}
+		updated = resp.RowsAffected
+		return tx.Delete(src).Error
+	})
+	if err != nil {
+		log.Printf("ERROR: unable to merge collection facet %s into %s: %s", src.Name, tgt.Name, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: facet %s merged into %s; %d metadata records updated", src.Name, tgt.Name, updated)
+
+	out := gin.H{"merged": updated}
+	if c.Query("reindex") == "true" && updated > 0 {
+		js, err := svc.reindexCollectionFacet(c, tgt.Name)
+		if err != nil {
+			log.Printf("ERROR: unable to start reindex for facet %s: %s", tgt.Name, err.Error())
+			c.String(http.StatusInternalServerError, fmt.Sprintf("facets merged but reindex failed to start: %s", err.Error()))
+			return
+		}
+		out["jobID"] = js.ID
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// deleteCollectionFacet removes a facet that is not used by any metadata record
+func (svc *serviceContext) deleteCollectionFacet(c *gin.Context) {
+	facet, ok := svc.loadCollectionFacet(c, c.Param("id"))
+	if ok == false {
+		return
+	}
+
+	var usage int64
+	err := svc.DB.Table("metadata").Where("collection_facet=?", facet.Name).Count(&usage).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get usage of collection facet %s: %s", facet.Name, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if usage > 0 {
+		log.Printf("INFO: unable to delete collection facet %s; it is used by %d records", facet.Name, usage)
+		c.String(http.StatusConflict, fmt.Sprintf("facet %s is used by %d metadata records", facet.Name, usage))
+		return
+	}
+
+	log.Printf("INFO: delete collection facet %s", facet.Name)
+	err = svc.DB.Delete(facet).Error
+	if err != nil {
+		log.Printf("ERROR: unable to delete collection facet %s: %s", facet.Name, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "deleted")
+}
+
+// loadCollectionFacet loads a facet by id. If it cannot be loaded, an error response is sent and false is returned.
+func (svc *serviceContext) loadCollectionFacet(c *gin.Context, idStr string) (*collectionFacet, bool) {
+	facetID, _ := strconv.ParseUint(idStr, 10, 64)
+	if facetID == 0 {
+		log.Printf("ERROR: invalid collection facet id [%s]", idStr)
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid facet id %s", idStr))
+		return nil, false
+	}
+	var facet collectionFacet
+	err := svc.DB.Limit(1).Find(&facet, facetID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load collection facet %d: %s", facetID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if facet.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("facet %d not found", facetID))
+		return nil, false
+	}
+	return &facet, true
+}
+
+// reindexCollectionFacet starts a job that sends published XML metadata using the facet to the DL
+// reindex hook. Other published records pick up the facet the next time they are published.
+func (svc *serviceContext) reindexCollectionFacet(c *gin.Context, facetName string) (*jobStatus, error) {
+	var records []metadata
+	err := svc.DB.Select("id", "pid", "type", "date_dl_ingest", "date_dl_update").
+		Where("collection_facet=? and (date_dl_ingest is not null or date_dl_update is not null)", facetName).Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claims := getClaims(c)
+	js, err := svc.createJobStatus("CollectionFacetReindex", "StaffMember", fmt.Sprintf("%d", claims.UserID))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		svc.logJobEvent(js, 0, fmt.Sprintf("Reindex %d published records with collection facet %s", len(records), facetName))
+		reindexed := 0
+		failed := 0
+		for idx := range records {
+			md := &records[idx]
+			if md.Type != "XmlMetadata" {
+				svc.logJobEvent(js, 1, fmt.Sprintf("%s is %s and must be republished to update the facet", md.PID, md.Type))
+				continue
+			}
+			err := svc.reindexXMLMetadata(md)
+			time.Sleep(50 * time.Millisecond)
+			if err != nil {
+				failed++
+				svc.logJobEvent(js, 2, fmt.Sprintf("%s was not reindexed: %s", md.PID, err.Error()))
+				continue
+			}
+			reindexed++
+		}
+		svc.logJobEvent(js, 0, fmt.Sprintf("%d records queued for reindex, %d failed", reindexed, failed))
+		svc.finishJobStatus(js, "")
+	}()
+	return js, nil
+}
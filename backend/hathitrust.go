@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -46,6 +47,31 @@ type hathiTrustBatchUpdateRequest struct {
 	Value     string  `json:"value"`
 }
 
+type hathitrustStatusHistory struct {
+	ID                 uint         `json:"id"`
+	HathiTrustStatusID uint         `gorm:"column:hathitrust_status_id" json:"hathiTrustStatusID"`
+	StaffMemberID      *uint        `json:"-"`
+	StaffMember        *staffMember `gorm:"foreignKey:StaffMemberID" json:"staffMember,omitempty"`
+	Field              string       `json:"field"`
+	OldValue           string       `json:"oldValue"`
+	NewValue           string       `json:"newValue"`
+	Source             string       `json:"source"`
+	CreatedAt          time.Time    `json:"createdAt"`
+}
+
+// hathiTrustStatusValues are the allowed values for package_status and metadata_status
+var hathiTrustStatusValues = []string{"pending", "submitted", "accepted", "failed"}
+
+// hathiTrustBatchFields are the columns that can be set by a batch update, and whether each holds a date
+var hathiTrustBatchFields = map[string]bool{
+	"package_created_at":    true,
+	"package_submitted_at":  true,
+	"package_status":        false,
+	"metadata_submitted_at": true,
+	"metadata_status":       false,
+	"finished_at":           true,
+}
+
 type hathiTrustSubmissionsResonse struct {
 	Total       int64              `json:"total"`
 	Submissions []hathitrustStatus `json:"submissions"`
@@ -114,19 +140,20 @@ func (svc *serviceContext) getHathiTrustSubmissions(c *gin.Context) {
 	}
 
 	resp := hathiTrustSubmissionsResonse{}
-	err := svc.DB.Table("hathitrust_statuses").Count(&resp.Total).Error
+	searchQ := svc.DB.Model(&hathitrustStatus{}).Joins("Metadata").
+		Where("title like ? or barcode like ?", fmt.Sprintf("%%%s%%", queryStr), fmt.Sprintf("%s%%", queryStr))
+	if filterQ != nil {
+		searchQ = searchQ.Where(filterQ)
+	}
+	searchQ = searchQ.Session(&gorm.Session{})
+	err := searchQ.Count(&resp.Total).Error
 	if err != nil {
 		log.Printf("ERROR: unable to get hathi submissions count: %s", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	searchQ := svc.DB.Joins("Metadata").Order(orderStr).Offset(startIndex).Limit(pageSize).
-		Where("title like ? or barcode like ?", fmt.Sprintf("%%%s%%", queryStr), fmt.Sprintf("%s%%", queryStr))
-	if filterQ != nil {
-		searchQ = searchQ.Where(filterQ)
-	}
-	err = searchQ.Debug().Find(&resp.Submissions).Error
+	err = searchQ.Order(orderStr).Offset(startIndex).Limit(pageSize).Find(&resp.Submissions).Error
 	if err != nil {
 		log.Printf("ERROR: unable to get hathi submissions: %s", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
@@ -136,6 +163,9 @@ func (svc *serviceContext) getHathiTrustSubmissions(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// updateHathiTrustSubmissions sets one whitelisted field on a batch of hathitrust statuses. Dates must be
+// yyyy-mm-dd (an empty value clears the date) and statuses must be one of hathiTrustStatusValues. Every
+// changed value is recorded in the status history.
 func (svc *serviceContext) updateHathiTrustSubmissions(c *gin.Context) {
 	log.Printf("INFO: received batch hathitrust update request")
 
@@ -153,6 +183,33 @@ func (svc *serviceContext) updateHathiTrustSubmissions(c *gin.Context) {
 		return
 	}
 
+	isDate, valid := hathiTrustBatchFields[req.Field]
+	if valid == false {
+		log.Printf("INFO: batch update hathitrust request has unsupported field [%s]", req.Field)
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s cannot be batch updated", req.Field))
+		return
+	}
+	var newValue any
+	req.Value = strings.TrimSpace(req.Value)
+	if isDate {
+		if req.Value != "" {
+			dateVal, err := parseDateString(req.Value)
+			if err != nil {
+				log.Printf("INFO: batch update hathitrust request has invalid %s date [%s]", req.Field, req.Value)
+				c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid date", req.Value))
+				return
+			}
+			newValue = dateVal
+		}
+	} else {
+		if slices.Contains(hathiTrustStatusValues, req.Value) == false {
+			log.Printf("INFO: batch update hathitrust request has invalid %s status [%s]", req.Field, req.Value)
+			c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid status", req.Value))
+			return
+		}
+		newValue = req.Value
+	}
+
 	statusIDs := req.StatusIDs
 	if req.OderID > 0 {
 		log.Printf("INFO: batch update hathitrust %s=%s for order %d", req.Field, req.Value, req.OderID)
@@ -166,7 +223,31 @@ func (svc *serviceContext) updateHathiTrustSubmissions(c *gin.Context) {
 		}
 	}
 
-	err = svc.DB.Exec(fmt.Sprintf("update hathitrust_statuses set %s=? where id in ?", req.Field), req.Value, statusIDs).Error
+	var statuses []hathitrustStatus
+	err = svc.DB.Where("id in ?", statusIDs).Find(&statuses).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get hathitrust statuses for update: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	claims := getClaims(c)
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		for idx := range statuses {
+			hs := &statuses[idx]
+			oldValue := hs.fieldValue(req.Field)
+			if oldValue == req.Value {
+				continue
+			}
+			if err := tx.Model(hs).Update(req.Field, newValue).Error; err != nil {
+				return err
+			}
+			if err := recordHathiTrustHistory(tx, hs.ID, &claims.UserID, req.Field, oldValue, req.Value, "batch"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		log.Printf("ERROR: unable to update hathitrust status hathitrust %s=%s: %s", req.Field, req.Value, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
@@ -176,6 +257,50 @@ func (svc *serviceContext) updateHathiTrustSubmissions(c *gin.Context) {
 	c.String(http.StatusOK, "updated")
 }
 
+// getHathiTrustHistory returns the status change history for the hathitrust status of a metadata record
+func (svc *serviceContext) getHathiTrustHistory(c *gin.Context) {
+	mdID := c.Param("id")
+	var history []hathitrustStatusHistory
+	err := svc.DB.Preload("StaffMember").
+		Joins("inner join hathitrust_statuses hs on hs.id = hathitrust_status_histories.hathitrust_status_id").
+		Where("hs.metadata_id=?", mdID).Order("hathitrust_status_histories.created_at asc").Find(&history).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get hathitrust status history for metadata %s: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// fieldValue returns the value of a batch update column as it is submitted in a batch request
+func (hs *hathitrustStatus) fieldValue(field string) string {
+	var dateVal *time.Time
+	switch field {
+	case "package_status":
+		return hs.PackageStatus
+	case "metadata_status":
+		return hs.MetadataStatus
+	case "package_created_at":
+		dateVal = hs.PackageCreatedAt
+	case "package_submitted_at":
+		dateVal = hs.PackageSubmittedAt
+	case "metadata_submitted_at":
+		dateVal = hs.MetadataSubmittedAt
+	case "finished_at":
+		dateVal = hs.FinishedAt
+	}
+	if dateVal == nil {
+		return ""
+	}
+	return dateVal.Format("2006-01-02")
+}
+
+func recordHathiTrustHistory(tx *gorm.DB, statusID uint, staffID *uint, field, oldValue, newValue, source string) error {
+	hist := hathitrustStatusHistory{HathiTrustStatusID: statusID, StaffMemberID: staffID, Field: field,
+		OldValue: oldValue, NewValue: newValue, Source: source, CreatedAt: time.Now()}
+	return tx.Create(&hist).Error
+}
+
 func (svc *serviceContext) updateHathiTrustStatus(c *gin.Context) {
 	mdID := c.Param("id")
 	log.Printf("INFO: received hathitrust update request for metadata %s", mdID)
@@ -202,6 +327,11 @@ func (svc *serviceContext) updateHathiTrustStatus(c *gin.Context) {
 		return
 	}
 
+	oldValues := make(map[string]string)
+	for field := range hathiTrustBatchFields {
+		oldValues[field] = md.HathiTrustStatus.fieldValue(field)
+	}
+
 	md.HathiTrustStatus.MetadataStatus = req.MetadataStatus
 	md.HathiTrustStatus.PackageStatus = req.PackageStatus
 	md.HathiTrustStatus.Notes = req.Notes
@@ -251,7 +381,22 @@ func (svc *serviceContext) updateHathiTrustStatus(c *gin.Context) {
 		updates = append(updates, "MetadataSubmittedAt")
 	}
 
-	err = svc.DB.Model(md.HathiTrustStatus).Select(updates).Updates(md.HathiTrustStatus).Error
+	claims := getClaims(c)
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(md.HathiTrustStatus).Select(updates).Updates(md.HathiTrustStatus).Error; err != nil {
+			return err
+		}
+		for field, oldValue := range oldValues {
+			newValue := md.HathiTrustStatus.fieldValue(field)
+			if newValue == oldValue {
+				continue
+			}
+			if err := recordHathiTrustHistory(tx, md.HathiTrustStatus.ID, &claims.UserID, field, oldValue, newValue, "edit"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		log.Printf("ERROR: hathiutrust status update for metadata %d failed: %s", md.ID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
@@ -298,7 +443,12 @@ func (svc *serviceContext) removeMetadataFromHathiTrust(mdID int64) error {
 	}
 
 	if htStatus.ID > 0 {
-		err = svc.DB.Delete(&htStatus).Error
+		err = svc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("hathitrust_status_id=?", htStatus.ID).Delete(&hathitrustStatusHistory{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&htStatus).Error
+		})
 		if err != nil {
 			return fmt.Errorf("unable to delete hathitrust status for metadata %d: %s", mdID, err.Error())
 		}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// hathiTrustContributor is the contributor code used in the zephir item fields and file names
+const hathiTrustContributor = "uva"
+
+var volumeRegex = regexp.MustCompile(`(?i)\b(v|vol|no|pt|bd|t)\.\s*\S.*$`)
+
+// getHathiTrustMetadataFile builds the zephir MARC submission for HathiTrust flagged SirsiMetadata records,
+// selected by a comma separated list of metadata ids or by order. The response is a zip containing the
+// MARCXML collection and the tracking spreadsheet. Records included in the file have MetadataSubmittedAt
+// set unless dryrun=true.
+func (svc *serviceContext) getHathiTrustMetadataFile(c *gin.Context) {
+	dryRun := c.Query("dryrun") == "true"
+	mdQ := svc.DB.Preload("HathiTrustStatus").Where("hathitrust=? and type=?", 1, "SirsiMetadata")
+	if c.Query("ids") != "" {
+		ids := make([]int64, 0)
+		for _, idStr := range strings.Split(c.Query("ids"), ",") {
+			id, _ := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+			if id == 0 {
+				log.Printf("ERROR: invalid metadata id [%s] in hathitrust metadata file request", idStr)
+				c.String(http.StatusBadRequest, fmt.Sprintf("invalid metadata id %s", idStr))
+				return
+			}
+			ids = append(ids, id)
+		}
+		mdQ = mdQ.Where("id in ?", ids)
+	} else if orderID, _ := strconv.ParseInt(c.Query("order"), 10, 64); orderID > 0 {
+		mdQ = mdQ.Where("id in (select metadata_id from units where order_id=?)", orderID)
+	} else {
+		c.String(http.StatusBadRequest, "ids or order is required")
+		return
+	}
+
+	var records []metadata
+	err := mdQ.Order("id asc").Find(&records).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get metadata for hathitrust submission: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(records) == 0 {
+		c.String(http.StatusNotFound, "no hathitrust flagged sirsi metadata records match the request")
+		return
+	}
+
+	log.Printf("INFO: generate hathitrust metadata submission for %d records; dry run %t", len(records), dryRun)
+	var buf bytes.Buffer
+	trackW := csv.NewWriter(&buf)
+	trackW.Write([]string{"barcode", "catalog_key", "oclc_number", "title", "call_number", "volume", "pid"})
+	collection := marcCollection{Records: make([]marcRecord, 0)}
+	failures := make([]string, 0)
+	submitted := make([]*hathitrustStatus, 0)
+	for idx := range records {
+		md := &records[idx]
+		if md.HathiTrustStatus == nil {
+			failures = append(failures, fmt.Sprintf("%s: no hathitrust status", md.PID))
+			continue
+		}
+		if md.Barcode == nil || strings.TrimSpace(*md.Barcode) == "" {
+			failures = append(failures, fmt.Sprintf("%s: no barcode", md.PID))
+			continue
+		}
+		catKey := ""
+		if md.CatalogKey != nil {
+			catKey = *md.CatalogKey
+		}
+		marc, err := svc.getSirsiMARC(catKey, *md.Barcode)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", md.PID, err.Error()))
+			continue
+		}
+
+		volume := ""
+		if md.CallNumber != nil {
+			volume = strings.TrimSpace(volumeRegex.FindString(*md.CallNumber))
+		}
+		rec := hathiTrustMARCRecord(&marc.Record, strings.TrimSpace(*md.Barcode), volume)
+		collection.Records = append(collection.Records, *rec)
+		submitted = append(submitted, md.HathiTrustStatus)
+
+		callNumber := ""
+		if md.CallNumber != nil {
+			callNumber = *md.CallNumber
+		}
+		trackW.Write([]string{strings.TrimSpace(*md.Barcode), catKey, marcOCLCNumber(rec), md.Title, callNumber, volume, md.PID})
+		time.Sleep(50 * time.Millisecond)
+	}
+	trackW.Flush()
+
+	if len(collection.Records) == 0 {
+		log.Printf("INFO: no records could be added to the hathitrust submission: %v", failures)
+		c.String(http.StatusUnprocessableEntity, strings.Join(failures, "\n"))
+		return
+	}
+
+	marcXML, err := marshalExportXML(collection)
+	if err != nil {
+		log.Printf("ERROR: unable to generate hathitrust marc file: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	baseName := fmt.Sprintf("%s_%s", hathiTrustContributor, time.Now().Format("20060102"))
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	zipFiles := map[string][]byte{
+		fmt.Sprintf("%s.xml", baseName): marcXML,
+		fmt.Sprintf("%s.csv", baseName): buf.Bytes(),
+	}
+	if len(failures) > 0 {
+		zipFiles["errors.txt"] = []byte(strings.Join(failures, "\n"))
+	}
+	for name, data := range zipFiles {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to add %s to hathitrust submission zip: %s", name, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	zw.Close()
+
+	if dryRun == false {
+		claims := getClaims(c)
+		now := time.Now()
+		err = svc.DB.Transaction(func(tx *gorm.DB) error {
+			for _, hs := range submitted {
+				oldValue := hs.fieldValue("metadata_submitted_at")
+				hs.MetadataSubmittedAt = &now
+				if err := tx.Model(hs).Select("MetadataSubmittedAt").Updates(hs).Error; err != nil {
+					return err
+				}
+				newValue := hs.fieldValue("metadata_submitted_at")
+				if err := recordHathiTrustHistory(tx, hs.ID, &claims.UserID, "metadata_submitted_at", oldValue, newValue, "metadata file"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: unable to set hathitrust metadata submitted date: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	log.Printf("INFO: hathitrust metadata submission %s has %d records and %d failures", baseName, len(collection.Records), len(failures))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", baseName))
+	c.Data(http.StatusOK, "application/zip", zipBuf.Bytes())
+}
+
+// hathiTrustMARCRecord copies a catalog record and replaces any item fields with the 955 (barcode and
+// volume) and 974 (contributor and item id) fields required by zephir
+func hathiTrustMARCRecord(src *marcRecord, barcode, volume string) *marcRecord {
+	out := marcRecord{Leader: src.Leader, ControlFields: src.ControlFields, DataFields: make([]dataField, 0)}
+	for _, df := range src.DataFields {
+		if df.Tag == "955" || df.Tag == "974" {
+			continue
+		}
+		df.Value = ""
+		out.DataFields = append(out.DataFields, df)
+	}
+
+	f955 := dataField{Tag: "955", Ind1: " ", Ind2: " ", Subfields: []subField{{Code: "b", Value: barcode}}}
+	if volume != "" {
+		f955.Subfields = append(f955.Subfields, subField{Code: "v", Value: volume})
+	}
+	f974 := dataField{Tag: "974", Ind1: " ", Ind2: " ", Subfields: []subField{
+		{Code: "b", Value: strings.ToUpper(hathiTrustContributor)},
+		{Code: "c", Value: strings.ToUpper(hathiTrustContributor)},
+		{Code: "u", Value: fmt.Sprintf("%s.%s", hathiTrustContributor, strings.ToLower(barcode))},
+	}}
+	out.DataFields = append(out.DataFields, f955, f974)
+	return &out
+}
+
+// marcOCLCNumber returns the OCLC number from the 035 fields of a record
+func marcOCLCNumber(rec *marcRecord) string {
+	for _, df := range rec.DataFields {
+		if df.Tag != "035" {
+			continue
+		}
+		for _, sf := range df.Subfields {
+			if sf.Code == "a" && strings.HasPrefix(sf.Value, "(OCoLC)") {
+				return strings.TrimLeft(strings.TrimPrefix(sf.Value, "(OCoLC)"), "ocmn ")
+			}
+		}
+	}
+	return ""
+}
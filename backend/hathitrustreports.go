@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// hathiTrustItemRegex matches the HathiTrust item id (uva.<barcode>) used in load and zephir reports
+var hathiTrustItemRegex = regexp.MustCompile(`(?i)\b` + hathiTrustContributor + `\.([a-z0-9]+)\b`)
+
+// hathiTrustFailedStatusRegex matches the value of a TSV status column that reports a problem with an item
+var hathiTrustFailedStatusRegex = regexp.MustCompile(`(?i)^(fail|failed|failure|reject|rejected|error)\b`)
+
+// hathiTrustFailureRegex identifies email report lines with a failure status, such as "failed" or "error: ..."
+var hathiTrustFailureRegex = regexp.MustCompile(`(?i)\b(failed|rejected)\b|\berror:`)
+
+// hathiTrustCountRegex matches summary counts such as "0 errors" that must not be read as a failure status
+var hathiTrustCountRegex = regexp.MustCompile(`(?i)\b\d+\s+(errors?|failed|failures?|rejected)\b`)
+
+type hathiTrustReportRow struct {
+	Line    int    `json:"line"`
+	Barcode string `json:"barcode"`
+	Text    string `json:"text"`
+	Failed  bool   `json:"failed"`
+}
+
+type hathiTrustReportUpdate struct {
+	StatusID   uint   `json:"statusID"`
+	MetadataID int64  `json:"metadataID"`
+	Barcode    string `json:"barcode"`
+	Status     string `json:"status"`
+	Finished   bool   `json:"finished"`
+}
+
+type hathiTrustReportResponse struct {
+	Rows      int                      `json:"rows"`
+	Updated   []hathiTrustReportUpdate `json:"updated"`
+	Unmatched []hathiTrustReportRow    `json:"unmatched"`
+}
+
+// ingestHathiTrustReport applies a HathiTrust load report (type=package) or zephir report (type=metadata)
+// to the matching hathitrust statuses. The report may be TSV or the text of the notification email; any
+// line with a uva.<barcode> item id, or a barcode column in TSV, is treated as a row. A TSV status (or result)
+// column decides whether a row failed; without one, rows with a failure status such as "failed", "rejected" or
+// "error:" fail. Failed rows mark the stage failed and are appended to the notes, other rows mark it accepted.
+// An item is finished once both package and metadata are accepted. Rows that match no status are returned.
+func (svc *serviceContext) ingestHathiTrustReport(c *gin.Context) {
+	reportType := c.Query("type")
+	if reportType != "package" && reportType != "metadata" {
+		c.String(http.StatusBadRequest, "type must be package or metadata")
+		return
+	}
+	dryRun := c.Query("dryrun") == "true"
+
+	var reportData []byte
+	formFile, err := c.FormFile("file")
+	if err == nil {
+		f, openErr := formFile.Open()
+		if openErr != nil {
+			log.Printf("ERROR: unable to open hathitrust report %s: %s", formFile.Filename, openErr.Error())
+			c.String(http.StatusBadRequest, openErr.Error())
+			return
+		}
+		reportData, err = io.ReadAll(f)
+		f.Close()
+	} else {
+		reportData, err = io.ReadAll(c.Request.Body)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to read hathitrust report: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows := parseHathiTrustReport(string(reportData))
+	if len(rows) == 0 {
+		c.String(http.StatusBadRequest, "no hathitrust items found in report")
+		return
+	}
+	log.Printf("INFO: process hathitrust %s report with %d rows; dry run %t", reportType, len(rows), dryRun)
+
+	barcodes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		barcodes = append(barcodes, row.Barcode)
+	}
+	var statuses []hathitrustStatus
+	err = svc.DB.Joins("Metadata").Where("Metadata.barcode in ? and Metadata.hathitrust=?", barcodes, 1).Find(&statuses).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get hathitrust statuses for report: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	statusMap := make(map[string]*hathitrustStatus)
+	for idx := range statuses {
+		hs := &statuses[idx]
+		if hs.Metadata != nil && hs.Metadata.Barcode != nil {
+			statusMap[strings.ToLower(strings.TrimSpace(*hs.Metadata.Barcode))] = hs
+		}
+	}
+
+	// a barcode may appear on several rows; any failure row takes precedence over success rows
+	resp := hathiTrustReportResponse{Rows: len(rows), Updated: make([]hathiTrustReportUpdate, 0), Unmatched: make([]hathiTrustReportRow, 0)}
+	failures := make(map[string][]string)
+	order := make([]string, 0)
+	for _, row := range rows {
+		if _, found := statusMap[row.Barcode]; found == false {
+			resp.Unmatched = append(resp.Unmatched, row)
+			continue
+		}
+		if _, seen := failures[row.Barcode]; seen == false {
+			failures[row.Barcode] = make([]string, 0)
+			order = append(order, row.Barcode)
+		}
+		if row.Failed {
+			failures[row.Barcode] = append(failures[row.Barcode], row.Text)
+		}
+	}
+
+	now := time.Now()
+	for _, barcode := range order {
+		hs := statusMap[barcode]
+		origStatus := *hs
+		status := "accepted"
+		if len(failures[barcode]) > 0 {
+			status = "failed"
+			note := fmt.Sprintf("%s %s report: %s", now.Format("2006-01-02"), reportType, strings.Join(failures[barcode], "; "))
+			if hs.Notes != "" {
+				note = hs.Notes + "\n" + note
+			}
+			hs.Notes = note
+		}
+		fields := []string{"Notes"}
+		statusField := "package_status"
+		if reportType == "package" {
+			hs.PackageStatus = status
+			fields = append(fields, "PackageStatus")
+		} else {
+			statusField = "metadata_status"
+			hs.MetadataStatus = status
+			fields = append(fields, "MetadataStatus")
+		}
+		changes := map[string]string{statusField: origStatus.fieldValue(statusField)}
+		finished := false
+		if hs.PackageStatus == "accepted" && hs.MetadataStatus == "accepted" && hs.FinishedAt == nil {
+			hs.FinishedAt = &now
+			fields = append(fields, "FinishedAt")
+			changes["finished_at"] = ""
+			finished = true
+		}
+
+		if dryRun == false {
+			err = svc.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(hs).Select(fields).Updates(hs).Error; err != nil {
+					return err
+				}
+				for field, oldValue := range changes {
+					newValue := hs.fieldValue(field)
+					if newValue == oldValue {
+						continue
+					}
+					if err := recordHathiTrustHistory(tx, hs.ID, nil, field, oldValue, newValue, reportType+" report"); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("ERROR: unable to update hathitrust status %d from %s report: %s", hs.ID, reportType, err.Error())
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		resp.Updated = append(resp.Updated, hathiTrustReportUpdate{StatusID: hs.ID, MetadataID: hs.MetadataID, Barcode: barcode, Status: status, Finished: finished})
+	}
+
+	log.Printf("INFO: hathitrust %s report updated %d statuses; %d rows unmatched", reportType, len(resp.Updated), len(resp.Unmatched))
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseHathiTrustReport extracts the item rows from a report. Barcodes are returned in lower case.
+func parseHathiTrustReport(report string) []hathiTrustReportRow {
+	out := make([]hathiTrustReportRow, 0)
+	barcodeCol := -1
+	statusCol := -1
+	headerCol := func(cols []string, names ...string) int {
+		return slices.IndexFunc(cols, func(col string) bool {
+			return slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(strings.TrimSpace(col), name) })
+		})
+	}
+	for idx, line := range strings.Split(strings.ReplaceAll(report, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if barcodeCol < 0 && statusCol < 0 && len(cols) > 1 {
+			barcodeCol = headerCol(cols, "barcode")
+			statusCol = headerCol(cols, "status", "result")
+			if barcodeCol >= 0 || statusCol >= 0 {
+				continue
+			}
+		}
+
+		barcode := ""
+		text := line
+		if match := hathiTrustItemRegex.FindStringSubmatchIndex(line); match != nil {
+			barcode = line[match[2]:match[3]]
+			text = line[:match[0]] + line[match[1]:]
+		} else if barcodeCol >= 0 && barcodeCol < len(cols) {
+			barcode = strings.TrimSpace(cols[barcodeCol])
+			text = strings.Join(slices.Delete(slices.Clone(cols), barcodeCol, barcodeCol+1), "\t")
+		}
+		if barcode == "" {
+			continue
+		}
+		failed := false
+		if statusCol >= 0 {
+			failed = statusCol < len(cols) && hathiTrustFailedStatusRegex.MatchString(strings.TrimSpace(cols[statusCol]))
+		} else {
+			failed = hathiTrustFailureRegex.MatchString(hathiTrustCountRegex.ReplaceAllString(text, ""))
+		}
+		text = strings.Join(strings.Fields(strings.ReplaceAll(text, "\t", " ")), " ")
+		out = append(out, hathiTrustReportRow{Line: idx + 1, Barcode: strings.ToLower(barcode), Text: text, Failed: failed})
+	}
+	return out
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// createHathiTrustPackage starts a job that builds the HathiTrust submission package for a metadata record.
+// If the master files for the record span more than one unit, the unit param selects the one to package.
+func (svc *serviceContext) createHathiTrustPackage(c *gin.Context) {
+	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	unitID, _ := strconv.ParseInt(c.Query("unit"), 10, 64)
+	if svc.ArchiveDir == "" || svc.HTPackageDir == "" {
+		log.Printf("ERROR: hathitrust package requested but archive or package directory is not configured")
+		c.String(http.StatusServiceUnavailable, "hathitrust packaging is not configured")
+		return
+	}
+
+	var md metadata
+	err := svc.DB.Preload("HathiTrustStatus").Limit(1).Find(&md, mdID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load metadata %d: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if md.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("metadata %d not found", mdID))
+		return
+	}
+	if md.HathiTrust == false || md.HathiTrustStatus == nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not flagged for hathitrust", md.PID))
+		return
+	}
+	if md.Barcode == nil || strings.TrimSpace(*md.Barcode) == "" {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s has no barcode", md.PID))
+		return
+	}
+
+	mfQ := svc.DB.Preload("ImageTechMeta").Where("metadata_id=? and deaccessioned_at is null", md.ID)
+	if unitID > 0 {
+		mfQ = mfQ.Where("unit_id=?", unitID)
+	}
+	var masterFiles []*masterFile
+	err = mfQ.Order("filename asc").Find(&masterFiles).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get master files for metadata %d: %s", md.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(masterFiles) == 0 {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s has no master files", md.PID))
+		return
+	}
+	unitIDs := make([]int64, 0)
+	for _, mf := range masterFiles {
+		if slices.Contains(unitIDs, mf.UnitID) == false {
+			unitIDs = append(unitIDs, mf.UnitID)
+		}
+	}
+	if len(unitIDs) > 1 {
+		c.String(http.StatusBadRequest, fmt.Sprintf("master files for %s are in units %v; specify the unit to package", md.PID, unitIDs))
+		return
+	}
+
+	js, err := svc.createJobStatus("HathiTrustPackage", "Metadata", fmt.Sprintf("%d", md.ID))
+	if err != nil {
+		log.Printf("ERROR: unable to create hathitrust package job: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	go svc.buildHathiTrustPackage(js, &md, masterFiles)
+	c.JSON(http.StatusOK, gin.H{"jobID": js.ID, "total": len(masterFiles)})
+}
+
+// buildHathiTrustPackage writes <barcode>.zip to the package directory. The zip holds one image per page
+// named by sequence (00000001.jp2), matching OCR text files when any page has a transcription, meta.yml
+// and checksum.md5. A JPEG2000 derivative next to the archived master is used when present; otherwise
+// the archived TIF is packaged as-is.
+func (svc *serviceContext) buildHathiTrustPackage(js *jobStatus, md *metadata, masterFiles []*masterFile) {
+	barcode := strings.TrimSpace(*md.Barcode)
+	svc.logJobEvent(js, 0, fmt.Sprintf("Build hathitrust package for %s barcode %s with %d pages", md.PID, barcode, len(masterFiles)))
+
+	zipName := filepath.Join(svc.HTPackageDir, fmt.Sprintf("%s.zip", barcode))
+	tmpName := zipName + ".tmp"
+	zipFile, err := os.Create(tmpName)
+	if err != nil {
+		svc.finishJobStatus(js, fmt.Sprintf("Unable to create %s: %s", tmpName, err.Error()))
+		return
+	}
+
+	checksums, err := svc.writeHathiTrustPackage(js, zipFile, masterFiles)
+	zipFile.Close()
+	if err == nil {
+		err = validateHathiTrustPackage(tmpName, len(masterFiles), checksums)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, zipName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		svc.finishJobStatus(js, fmt.Sprintf("Unable to build hathitrust package: %s", err.Error()))
+		return
+	}
+
+	now := time.Now()
+	oldValue := md.HathiTrustStatus.fieldValue("package_created_at")
+	md.HathiTrustStatus.PackageCreatedAt = &now
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(md.HathiTrustStatus).Select("PackageCreatedAt").Updates(md.HathiTrustStatus).Error; err != nil {
+			return err
+		}
+		newValue := md.HathiTrustStatus.fieldValue("package_created_at")
+		return recordHathiTrustHistory(tx, md.HathiTrustStatus.ID, nil, "package_created_at", oldValue, newValue, "package job")
+	})
+	if err != nil {
+		svc.finishJobStatus(js, fmt.Sprintf("Package %s was created but the status could not be updated: %s", zipName, err.Error()))
+		return
+	}
+	svc.logJobEvent(js, 0, fmt.Sprintf("Package %s created", zipName))
+	svc.finishJobStatus(js, "")
+}
+
+// writeHathiTrustPackage writes the package contents to the zip and returns the md5 checksum of each file
+func (svc *serviceContext) writeHathiTrustPackage(js *jobStatus, out io.Writer, masterFiles []*masterFile) (map[string]string, error) {
+	zw := zip.NewWriter(out)
+	checksums := make(map[string]string)
+	addFile := func(name string, src io.Reader) error {
+		zf, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		hash := md5.New()
+		if _, err := io.Copy(io.MultiWriter(zf, hash), src); err != nil {
+			return fmt.Errorf("unable to add %s: %s", name, err.Error())
+		}
+		checksums[name] = fmt.Sprintf("%x", hash.Sum(nil))
+		return nil
+	}
+
+	hasOCR := slices.ContainsFunc(masterFiles, func(mf *masterFile) bool { return strings.TrimSpace(mf.TranscriptionText) != "" })
+	for idx, mf := range masterFiles {
+		pageName := fmt.Sprintf("%08d", idx+1)
+		srcPath := filepath.Join(svc.ArchiveDir, fmt.Sprintf("%09d", mf.UnitID), mf.Filename)
+		srcExt := filepath.Ext(mf.Filename)
+		jp2Path := strings.TrimSuffix(srcPath, srcExt) + ".jp2"
+		imgPath := srcPath
+		imgExt := strings.ToLower(srcExt)
+		if imgExt == ".tiff" {
+			// HathiTrust and the package validation only accept the .tif page image extension
+			imgExt = ".tif"
+		}
+		if _, err := os.Stat(jp2Path); err == nil {
+			imgPath = jp2Path
+			imgExt = ".jp2"
+		} else if mf.ImageTechMeta != nil && mf.ImageTechMeta.Depth > 1 {
+			svc.logJobEvent(js, 1, fmt.Sprintf("%s has no JPEG2000 derivative; HathiTrust requires JPEG2000 for continuous tone images", mf.Filename))
+		}
+
+		img, err := os.Open(imgPath)
+		if err != nil {
+			zw.Close()
+			return nil, fmt.Errorf("%s is not available: %s", mf.Filename, err.Error())
+		}
+		err = addFile(pageName+imgExt, img)
+		img.Close()
+		if err != nil {
+			zw.Close()
+			return nil, err
+		}
+		if imgPath == srcPath && mf.MD5 != "" && strings.EqualFold(checksums[pageName+imgExt], mf.MD5) == false {
+			zw.Close()
+			return nil, fmt.Errorf("%s checksum does not match the archived checksum", mf.Filename)
+		}
+
+		if hasOCR {
+			if err := addFile(pageName+".txt", strings.NewReader(mf.TranscriptionText)); err != nil {
+				zw.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if err := addFile("meta.yml", strings.NewReader(hathiTrustMetaYML(masterFiles))); err != nil {
+		zw.Close()
+		return nil, err
+	}
+
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var md5File strings.Builder
+	for _, name := range names {
+		md5File.WriteString(fmt.Sprintf("%s  %s\n", checksums[name], name))
+	}
+	zf, err := zw.Create("checksum.md5")
+	if err == nil {
+		_, err = zf.Write([]byte(md5File.String()))
+	}
+	if err != nil {
+		zw.Close()
+		return nil, fmt.Errorf("unable to add checksum.md5: %s", err.Error())
+	}
+	return checksums, zw.Close()
+}
+
+// hathiTrustMetaYML generates meta.yml using the capture details of the first page with technical metadata
+func hathiTrustMetaYML(masterFiles []*masterFile) string {
+	captureDate := masterFiles[0].CreatedAt
+	var techMeta *imageTechMeta
+	for _, mf := range masterFiles {
+		if mf.ImageTechMeta != nil {
+			techMeta = mf.ImageTechMeta
+			if techMeta.CaptureDate != nil {
+				captureDate = *techMeta.CaptureDate
+			}
+			break
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("capture_date: %s\n", captureDate.Format(time.RFC3339)))
+	if techMeta != nil {
+		if techMeta.Equipment != "" {
+			out.WriteString(fmt.Sprintf("scanner_make: %s\n", strconv.Quote(techMeta.Equipment)))
+		}
+		if techMeta.Model != "" {
+			out.WriteString(fmt.Sprintf("scanner_model: %s\n", strconv.Quote(techMeta.Model)))
+		}
+		if techMeta.Resolution > 0 {
+			out.WriteString(fmt.Sprintf("contone_resolution_dpi: %d\n", techMeta.Resolution))
+		}
+	}
+	out.WriteString(fmt.Sprintf("scanner_user: %s\n", strconv.Quote("University of Virginia Library: Digital Production Group")))
+	out.WriteString("scanning_order: left-to-right\n")
+	out.WriteString("reading_order: left-to-right\n")
+	return out.String()
+}
+
+// validateHathiTrustPackage reopens a package and checks that every page is present and that the
+// contents match the checksums recorded while it was written
+func validateHathiTrustPackage(zipPath string, pageCount int, checksums map[string]string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("package cannot be opened: %s", err.Error())
+	}
+	defer zr.Close()
+
+	pages := 0
+	found := make(map[string]bool)
+	for _, zf := range zr.File {
+		found[zf.Name] = true
+		ext := filepath.Ext(zf.Name)
+		if ext == ".jp2" || ext == ".tif" {
+			pages++
+			if zf.Name != fmt.Sprintf("%08d%s", pages, ext) {
+				return fmt.Errorf("page %s is out of sequence", zf.Name)
+			}
+		}
+		if zf.Name == "checksum.md5" {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("%s cannot be read: %s", zf.Name, err.Error())
+		}
+		hash := md5.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s cannot be read: %s", zf.Name, err.Error())
+		}
+		if fmt.Sprintf("%x", hash.Sum(nil)) != checksums[zf.Name] {
+			return fmt.Errorf("%s does not match its checksum", zf.Name)
+		}
+	}
+	if pages != pageCount {
+		return fmt.Errorf("package has %d pages but %d were expected", pages, pageCount)
+	}
+	if found["meta.yml"] == false || found["checksum.md5"] == false {
+		return fmt.Errorf("package is missing meta.yml or checksum.md5")
+	}
+	return nil
+}
@@ -120,3 +120,45 @@ func (svc *serviceContext) deleteJobStatuses(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, delReq)
 }
+
+// createJobStatus starts a job status record for work that is done in a goroutine by this service
+func (svc *serviceContext) createJobStatus(name, originatorType, originatorID string) (*jobStatus, error) {
+	js := jobStatus{Name: name, Status: "running", OriginatorType: originatorType, OriginatorID: originatorID, StartedAt: time.Now()}
+	err := svc.DB.Create(&js).Error
+	if err != nil {
+		return nil, err
+	}
+	return &js, nil
+}
+
+// logJobEvent adds an event to a job. Error level events also increment the job failure count.
+func (svc *serviceContext) logJobEvent(js *jobStatus, level uint, text string) {
+	if level >= 2 {
+		log.Printf("ERROR: [job %d] %s", js.ID, text)
+	} else {
+		log.Printf("INFO: [job %d] %s", js.ID, text)
+	}
+	evt := event{JobStatusID: js.ID, Level: level, Text: text, CreatedAt: time.Now()}
+	if err := svc.DB.Create(&evt).Error; err != nil {
+		log.Printf("ERROR: unable to add event to job %d: %s", js.ID, err.Error())
+	}
+	if level >= 2 {
+		js.Failures++
+		svc.DB.Model(js).Update("failures", js.Failures)
+	}
+}
+
+// finishJobStatus marks a job finished, or failed if errMsg is not empty
+func (svc *serviceContext) finishJobStatus(js *jobStatus, errMsg string) {
+	now := time.Now()
+	js.EndedAt = &now
+	js.Status = "finished"
+	if errMsg != "" {
+		js.Status = "failure"
+		js.Error = errMsg
+		svc.logJobEvent(js, 3, errMsg)
+	}
+	if err := svc.DB.Model(js).Select("Status", "Error", "EndedAt").Updates(js).Error; err != nil {
+		log.Printf("ERROR: unable to finish job %d: %s", js.ID, err.Error())
+	}
+}
@@ -33,6 +33,8 @@ func main() {
 	router.GET("/config", svc.getConfig)
 	router.POST("/upload_search_image", svc.uploadSearchImage)
 	router.GET("/pdf", svc.downloadPDF)
+	router.GET("/oai", svc.oaiRequest)
+	router.POST("/oai", svc.oaiRequest)
 
 	router.POST("/script", svc.scriptRunner)
 
@@ -41,19 +43,41 @@ func main() {
 		api.POST("/agency", svc.addAgency)
 
 		api.GET("/archivesspace", svc.getArchivesSpaceReviews)
+		api.GET("/archivesspace/metrics", svc.getArchivesSpaceMetrics)
+		api.POST("/archivesspace/assign", svc.assignArchivesSpaceReviews)
+		api.GET("/archivesspace/publish/bulk", svc.getArchivesSpacePublishBatches)
+		api.POST("/archivesspace/publish/bulk", svc.publishArchivesSpaceBulk)
+		api.GET("/archivesspace/publish/bulk/:id", svc.getArchivesSpacePublishBatch)
 		api.GET("/hathitrust", svc.getHathiTrustSubmissions)
 		api.PUT("/hathitrust", svc.updateHathiTrustSubmissions)
+		api.GET("/hathitrust/metadata-file", svc.getHathiTrustMetadataFile)
+		api.POST("/hathitrust/reports", svc.ingestHathiTrustReport)
 
+		api.GET("/collection-facet", svc.getCollectionFacets)
 		api.POST("/collection-facet", svc.addCollectionFacet)
+		api.PUT("/collection-facet/:id", svc.renameCollectionFacet)
+		api.POST("/collection-facet/:id/merge", svc.mergeCollectionFacet)
+		api.DELETE("/collection-facet/:id", svc.deleteCollectionFacet)
 		api.GET("/collections", svc.getCollections)
 		api.GET("/collections/candidates", svc.findCollectionCandidates)
+		api.GET("/collections/tree", svc.getCollectionTree)
 		api.GET("/collections/:id", svc.getCollectionItems)
 		api.GET("/collections/:id/csv", svc.exportCollectionCSV)
+		api.GET("/collections/:id/export", svc.exportCollection)
 		api.DELETE("/collections/:id/items/:item", svc.removeCollectionItem)
+		api.POST("/collections/:id/items/:item/move", svc.moveCollectionItem)
 		api.POST("/collections/:id/item", svc.addCollectionItem)
+		api.POST("/collections/:id/items/bulk", svc.addCollectionItems)
 
+		api.POST("/components", svc.createComponent)
+		api.POST("/components/import", svc.importEADComponents)
 		api.GET("/components/:id", svc.getComponentTree)
+		api.PUT("/components/:id", svc.updateComponent)
+		api.DELETE("/components/:id", svc.deleteComponent)
+		api.POST("/components/:id/move", svc.moveComponent)
+		api.GET("/components/:id/ead", svc.exportComponentEAD)
 		api.GET("/components/:id/masterfiles", svc.getComponentMasterFiles)
+		api.POST("/components/:id/masterfiles", svc.assignComponentMasterFiles)
 
 		api.GET("/customers", svc.getCustomers)
 		api.POST("/customers", svc.addOrUpdateCustomer)
@@ -68,14 +92,26 @@ func main() {
 		api.DELETE("/masterfiles/:id/tags", svc.removeMasterFileTag)
 
 		api.GET("/metadata/sirsi", svc.lookupSirsiMetadata)
+		api.POST("/metadata/sirsi/refresh", svc.refreshSirsiMetadata)
+		api.GET("/metadata/sirsi/cache", svc.getSirsiCacheEntry)
+		api.DELETE("/metadata/sirsi/cache", svc.invalidateSirsiCache)
+		api.POST("/metadata/rights/report", svc.rightsReport)
 		api.GET("/metadata/archivesspace", svc.validateArchivesSpaceMetadata)
 		api.GET("/metadata/:id", svc.getMetadata)
 		api.POST("/metadata/:id", svc.updateMetadata)
 		api.DELETE("/metadata/:id", svc.deleteMetadata)
+		api.GET("/metadata/:id/hathitrust/history", svc.getHathiTrustHistory)
 		api.POST("/metadata/:id/hathitrust", svc.updateHathiTrustStatus)
+		api.POST("/metadata/:id/hathitrust/package", svc.createHathiTrustPackage)
+		api.POST("/metadata/:id/merge", svc.mergeMetadata)
 		api.POST("/metadata/:id/xml", svc.uploadXMLMetadata)
 		api.GET("/metadata/:id/xml", svc.getXMLMetadata)
+		api.GET("/metadata/:id/export", svc.exportMetadata)
+		api.GET("/metadata/:id/rights", svc.getRightsDetermination)
+		api.GET("/metadata/:id/mods/fields", svc.getMODSFields)
+		api.PUT("/metadata/:id/mods/fields", svc.updateMODSFields)
 		api.POST("/metadata", svc.createMetadata)
+		api.POST("/metadata/import", svc.importMetadata)
 
 		api.POST("/metadata/:id/archivesspace", svc.requestArchivesSpaceReview)
 		api.POST("/metadata/:id/archivesspace/review", svc.beginArchivesSpaceReview)
@@ -83,7 +119,11 @@ func main() {
 		api.POST("/metadata/:id/archivesspace/publish", svc.publishArchivesSpace)
 		api.POST("/metadata/:id/archivesspace/reject", svc.rejectArchivesSpaceSubmission)
 		api.DELETE("/metadata/:id/archivesspace", svc.cancelArchivesSpaceSubmission)
+		api.GET("/metadata/:id/archivesspace/comments", svc.getArchivesSpaceReviewComments)
+		api.POST("/metadata/:id/archivesspace/comments", svc.addArchivesSpaceReviewComment)
 		api.POST("/metadata/:id/archivesspace/notes", svc.updateArchivesSpaceSubmissionNotes)
+		api.GET("/metadata/:id/archivesspace/units", svc.getArchivesSpacePublishUnits)
+		api.PUT("/metadata/:id/archivesspace/unit", svc.selectArchivesSpacePublishUnit)
 
 		api.GET("/orders", svc.getOrders)
 		api.POST("/orders", svc.createOrder)
@@ -133,6 +173,7 @@ func main() {
 
 		// statistics reporting support
 		api.GET("/stats/archive", svc.getArchiveStats)
+		api.GET("/stats/hathitrust", svc.getHathiTrustStats)
 		api.GET("/stats/images", svc.getImageStats)
 		api.GET("/stats/metadata", svc.getMetadataStats)
 		api.GET("/stats/published", svc.getPublishedStats)
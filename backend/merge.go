@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// mergeTable is a table with a column that references a metadata record
+type mergeTable struct {
+	Name     string
+	Column   string
+	Single   bool         // the target may have only one row; source rows are dropped if the target already has one
+	Children []mergeChild // rows that reference a dropped source row are moved to the target row
+}
+
+// mergeChild is a table with a column that references the id of a mergeTable row
+type mergeChild struct {
+	Name   string
+	Column string
+}
+
+var metadataReferences = []mergeTable{
+	{Name: "units", Column: "metadata_id"},
+	{Name: "master_files", Column: "metadata_id"},
+	{Name: "locations", Column: "metadata_id"},
+	{Name: "sirsi_metadata_components", Column: "sirsi_metadata_id"},
+	{Name: "hathitrust_statuses", Column: "metadata_id", Single: true,
+		Children: []mergeChild{{Name: "hathitrust_status_histories", Column: "hathitrust_status_id"}}},
+	{Name: "archivesspace_reviews", Column: "metadata_id", Single: true,
+		Children: []mergeChild{{Name: "review_comments", Column: "archivesspace_review_id"}}},
+	{Name: "metadata", Column: "parent_metadata_id"},
+	{Name: "archivesspace_publish_items", Column: "metadata_id"},
+}
+
+type mergeResponse struct {
+	DryRun   bool             `json:"dryRun"`
+	Source   *metadata        `json:"source"`
+	Target   *metadata        `json:"target"`
+	Moved    map[string]int64 `json:"moved"`
+	Dropped  map[string]int64 `json:"dropped"`
+	Warnings []string         `json:"warnings"`
+}
+
+// mergeMetadata moves everything that references a duplicate metadata record to the target record,
+// then deletes the duplicate. With dryrun=true, only the counts of affected rows are returned.
+func (svc *serviceContext) mergeMetadata(c *gin.Context) {
+	srcID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	tgtID, _ := strconv.ParseInt(c.Query("into"), 10, 64)
+	dryRun := c.Query("dryrun") == "true"
+	if srcID == 0 || tgtID == 0 {
+		log.Printf("ERROR: invalid merge request from [%s] into [%s]", c.Param("id"), c.Query("into"))
+		c.String(http.StatusBadRequest, "source id and into target id are required")
+		return
+	}
+	if srcID == tgtID {
+		c.String(http.StatusBadRequest, "a metadata record cannot be merged into itself")
+		return
+	}
+
+	var src, tgt metadata
+	if err := svc.DB.Limit(1).Find(&src, srcID).Error; err != nil || src.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("metadata %d not found", srcID))
+		return
+	}
+	if err := svc.DB.Limit(1).Find(&tgt, tgtID).Error; err != nil || tgt.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("metadata %d not found", tgtID))
+		return
+	}
+	// the target cannot be inside the source at any depth; moving the source members onto it would create a parent loop
+	srcCollectionIDs, err := svc.getCollectionIDs(src.ID)
+	if err != nil {
+		log.Printf("ERROR: unable to get sub-collections of metadata %d: %s", src.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if slices.Contains(srcCollectionIDs, tgt.ID) || slices.Contains(srcCollectionIDs, tgt.ParentMetadataID) {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is a member of %s and cannot be the merge target", tgt.PID, src.PID))
+		return
+	}
+
+	log.Printf("INFO: merge metadata %s into %s; dry run %t", src.PID, tgt.PID, dryRun)
+	out := mergeResponse{DryRun: dryRun, Source: &src, Target: &tgt, Moved: make(map[string]int64),
+		Dropped: make(map[string]int64), Warnings: make([]string, 0)}
+	if src.Type != tgt.Type {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%s is %s but %s is %s", src.PID, src.Type, tgt.PID, tgt.Type))
+	}
+	if src.DateDLIngest != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("%s has been published to the digital library and will need to be removed from it", src.PID))
+	}
+
+	for _, tbl := range metadataReferences {
+		var srcCnt int64
+		if err := svc.DB.Table(tbl.Name).Where(fmt.Sprintf("%s=?", tbl.Column), src.ID).Count(&srcCnt).Error; err != nil {
+			log.Printf("ERROR: unable to count %s for metadata %d: %s", tbl.Name, src.ID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if srcCnt == 0 {
+			continue
+		}
+		if tbl.Single {
+			var tgtCnt int64
+			svc.DB.Table(tbl.Name).Where(fmt.Sprintf("%s=?", tbl.Column), tgt.ID).Count(&tgtCnt)
+			if tgtCnt > 0 {
+				out.Dropped[tbl.Name] = srcCnt
+				out.Warnings = append(out.Warnings, fmt.Sprintf("%s already has %s; the one from %s will be deleted", tgt.PID, tbl.Name, src.PID))
+				continue
+			}
+		}
+		out.Moved[tbl.Name] = srcCnt
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+
+	err = svc.DB.Transaction(func(tx *gorm.DB) error {
+		for _, tbl := range metadataReferences {
+			where := fmt.Sprintf("%s=?", tbl.Column)
+			if _, drop := out.Dropped[tbl.Name]; drop {
+				for _, child := range tbl.Children {
+					moveQ := fmt.Sprintf("update %s set %s=(select id from %s where %s=? limit 1) where %s in (select id from %s where %s)",
+						child.Name, child.Column, tbl.Name, tbl.Column, child.Column, tbl.Name, where)
+					if err := tx.Exec(moveQ, tgt.ID, src.ID).Error; err != nil {
+						return fmt.Errorf("unable to move %s: %s", child.Name, err.Error())
+					}
+				}
+				if err := tx.Exec(fmt.Sprintf("delete from %s where %s", tbl.Name, where), src.ID).Error; err != nil {
+					return fmt.Errorf("unable to remove %s: %s", tbl.Name, err.Error())
+				}
+				continue
+			}
+			if tbl.Name == "master_files" {
+				// the target keeps its own exemplar if it has one
+				var exemplars int64
+				tx.Table("master_files").Where("metadata_id=? and exemplar=?", tgt.ID, 1).Count(&exemplars)
+				if exemplars > 0 {
+					if err := tx.Exec("update master_files set exemplar=0 where metadata_id=?", src.ID).Error; err != nil {
+						return fmt.Errorf("unable to clear exemplar: %s", err.Error())
+					}
+				}
+			}
+			if err := tx.Exec(fmt.Sprintf("update %s set %s=? where %s", tbl.Name, tbl.Column, where), tgt.ID, src.ID).Error; err != nil {
+				return fmt.Errorf("unable to update %s: %s", tbl.Name, err.Error())
+			}
+		}
+		if err := tx.Exec("update job_statuses set originator_id=? where originator_type=? and originator_id=?", tgt.ID, "Metadata", src.ID).Error; err != nil {
+			return fmt.Errorf("unable to update job statuses: %s", err.Error())
+		}
+		if src.IsCollection && tgt.IsCollection == false && out.Moved["metadata"] > 0 {
+			tgt.IsCollection = true
+			if err := tx.Model(&tgt).Update("is_collection", true).Error; err != nil {
+				return fmt.Errorf("unable to flag target as a collection: %s", err.Error())
+			}
+		}
+		return tx.Delete(&metadata{}, src.ID).Error
+	})
+	if err != nil {
+		log.Printf("ERROR: merge of metadata %s into %s failed: %s", src.PID, tgt.PID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("INFO: metadata %s merged into %s: %+v", src.PID, tgt.PID, out.Moved)
+
+	if out.Moved["units"] > 0 && tgt.CallNumber != nil {
+		log.Printf("INFO: units moved to metadata %d; check for projects to update", tgt.ID)
+		jwt := getJWT(c)
+		go func() {
+			svc.updateMetadataRelatedProjects(tgt.ID, tgt.Title, *tgt.CallNumber, jwt)
+		}()
+	}
+
+	c.JSON(http.StatusOK, out)
+}
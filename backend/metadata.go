@@ -73,6 +73,8 @@ type metadata struct {
 	OCRLanguageHint      string              `json:"ocrLanguageHint"`
 	AvailabilityPolicyID *int64              `json:"-"`
 	AvailabilityPolicy   *availabilityPolicy `gorm:"foreignKey:AvailabilityPolicyID" json:"availabilityPolicy"`
+	UseRightID           *int64              `json:"-"` // sirsi records get use rights from the ILS; this is for all others
+	UseRight             *useRight           `gorm:"foreignKey:UseRightID" json:"useRight,omitempty"`
 	Locations            []location          `gorm:"foreignKey:MetadataID" json:"locations"`
 	ExternalSystemID     *int64              `json:"externalSystemID"`
 	ExternalSystem       *externalSystem     `gorm:"foreignKey:ExternalSystemID" json:"externalSystem"`
@@ -174,6 +176,7 @@ type metadataDetailResponse struct {
 	ViewerURL           string               `json:"viewerURL,omitempty"`
 	VirgoURL            string               `json:"virgoURL,omitempty"`
 	Error               string               `json:"error"`
+	Warning             string               `json:"warning,omitempty"`
 }
 
 type metadataRequest struct {
@@ -231,10 +234,13 @@ func (svc *serviceContext) getMetadata(c *gin.Context) {
 		c.String(http.StatusNotFound, "metadata record not found")
 		return
 	}
+	if resp.ArchivesSpaceReview != nil {
+		resp.ArchivesSpaceReview.setActions(int64(getClaims(c).UserID))
+	}
 
 	if resp.Metadata.IsCollection {
 		log.Printf("INFO: metadata %d is a collection; load collection units", resp.Metadata.ID)
-		units, err := svc.getCollectionUnits(resp.Metadata.ID)
+		units, err := svc.getCollectionUnits(resp.Metadata.ID, c.Query("descendants") == "true")
 		if err != nil {
 			log.Printf("ERROR: unable to get units for collection %d: %s", resp.Metadata.ID, err.Error())
 		} else {
@@ -345,11 +351,7 @@ func (svc *serviceContext) createMetadata(c *gin.Context) {
 
 	switch req.Type {
 	case "XmlMetadata":
-		xmlMD := strings.Replace(modsTemplate, "[TITLE]", req.Title, 1)
-		if req.Author != "" {
-			xmlMD += strings.Replace(modsAuthor, "[AUTHOR]", req.Author, 1)
-		}
-		xmlMD += "</mods>"
+		xmlMD := newMODSRecord(req.Title, req.Author)
 		newMD.DescMetadata = &xmlMD
 	case "SirsiMetadata":
 		newMD.Barcode = &req.Barcode
@@ -383,6 +385,15 @@ func (svc *serviceContext) createMetadata(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// newMODSRecord generates the initial MODS for a new XML metadata record
+func newMODSRecord(title, author string) string {
+	xmlMD := strings.Replace(modsTemplate, "[TITLE]", modsTextEscaper.Replace(title), 1)
+	if author != "" {
+		xmlMD += strings.Replace(modsAuthor, "[AUTHOR]", modsTextEscaper.Replace(author), 1)
+	}
+	return xmlMD + "</mods>"
+}
+
 func (svc *serviceContext) deleteMetadata(c *gin.Context) {
 	mdID := c.Param("id")
 	log.Printf("INFO: received request to delete metadata %s", mdID)
@@ -555,6 +566,9 @@ func (svc *serviceContext) updateMetadata(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if resp.ArchivesSpaceReview != nil {
+		resp.ArchivesSpaceReview.setActions(int64(getClaims(c).UserID))
+	}
 	c.JSON(http.StatusOK, *resp)
 }
 
@@ -602,11 +616,14 @@ func (svc *serviceContext) sendUseRightToSirsi(md *metadata, useRightID int64) {
 		return
 	}
 	log.Printf("INFO: ils rights updated success: %s", ilsResp)
+
+	// the cached lookup no longer reflects the rights in sirsi
+	svc.SirsiCache.invalidate(svc.DB, *md.CatalogKey, "")
 }
 
 func (svc *serviceContext) loadMetadataDetails(mdID int64) (*metadataDetailResponse, error) {
 	var md *metadata
-	err := svc.DB.Preload("OCRHint").Preload("AvailabilityPolicy").
+	err := svc.DB.Preload("OCRHint").Preload("AvailabilityPolicy").Preload("UseRight").
 		Preload("ExternalSystem").Preload("SupplementalSystem").Preload("HathiTrustStatus").Preload("Locations").
 		Limit(1).Find(&md, mdID).Error
 	if err != nil {
@@ -674,11 +691,12 @@ func (svc *serviceContext) loadMetadataDetails(mdID int64) (*metadataDetailRespo
 		if md.Barcode != nil {
 			barcode = *md.Barcode
 		}
-		sirsiResp, err := svc.doSirsiLookup(catKey, barcode)
+		sirsiResp, warning, err := svc.cachedSirsiLookup(catKey, barcode, false)
 		if err != nil {
 			log.Printf("ERROR: lookup sirsi details for %s failed: %s", md.PID, err.Error())
 			out.Error = err.Error()
 		} else {
+			out.Warning = warning
 			out.Sirsi = &sirsiMetadata{
 				Title:             sirsiResp.Title,
 				CallNumber:        sirsiResp.CallNumber,
@@ -698,29 +716,25 @@ func (svc *serviceContext) loadMetadataDetails(mdID int64) (*metadataDetailRespo
 		switch md.ExternalSystem.Name {
 		case "ArchivesSpace":
 			log.Printf("INFO: get external ArchivesSpace metadata for %s", md.PID)
-			raw, getErr := svc.getRequest(fmt.Sprintf("%s/archivesspace/lookup?pid=%s&uri=%s", svc.ExternalSystems.Jobs, md.PID, *md.ExternalURI))
-			if getErr != nil {
-				log.Printf("ERROR: unable to get archivesSpace metadata for %s: %s", md.PID, getErr.Message)
+			asData, asErr := svc.getArchivesSpaceMetadata(md)
+			if asErr != nil {
+				log.Printf("ERROR: unable to get archivesSpace metadata for %s: %s", md.PID, asErr.Error())
 			} else {
-				asData := asMetadata{}
-				err := json.Unmarshal(raw, &asData)
-				if err != nil {
-					log.Printf("ERROR: unable to parse AS response for %s: %s", md.PID, err.Error())
-				} else {
-					if md.CallNumber == nil {
-						log.Printf("INFO: metadata record does not have call number set to as accession id; update")
-						md.CallNumber = &asData.CollectionID
-						if err := svc.DB.Model(md).Update("call_number", md.CallNumber).Error; err != nil {
-							log.Printf("ERROR: unable to update callnum data: %s", err.Error())
-						}
+				if md.CallNumber == nil {
+					log.Printf("INFO: metadata record does not have call number set to as accession id; update")
+					md.CallNumber = &asData.CollectionID
+					if err := svc.DB.Model(md).Update("call_number", md.CallNumber).Error; err != nil {
+						log.Printf("ERROR: unable to update callnum data: %s", err.Error())
 					}
-					out.ArchiveSpace = &asData
 				}
+				out.ArchiveSpace = asData
 				log.Printf("Parsed AS metadta collectionID=%s", asData.CollectionID)
 			}
 
 			var reviewInfo archivesspaceReview
-			err = svc.DB.Preload("Submitter").Preload("Reviewer").Where("metadata_id=?", md.ID).Limit(1).Find(&reviewInfo).Error
+			err = svc.DB.Preload("Submitter").Preload("Reviewer").
+				Preload("Comments", func(db *gorm.DB) *gorm.DB { return db.Order("created_at asc") }).Preload("Comments.Author").
+				Where("metadata_id=?", md.ID).Limit(1).Find(&reviewInfo).Error
 			if err != nil {
 				log.Printf("ERROR: unable to load archivesspace review info: %s", err.Error())
 			} else {
@@ -916,21 +930,7 @@ func (svc *serviceContext) uploadXMLMetadata(c *gin.Context) {
 		return
 	}
 
-	if md.DateDLIngest != nil || md.DateDLUpdate != nil {
-		log.Printf("INFO: call xml reindexing hook for previously published metadata %s", md.PID)
-		_, putErr := svc.putRequest(fmt.Sprintf("%s/%d", svc.ExternalSystems.XMLIndex, md.ID))
-		if putErr != nil {
-			log.Printf("ERROR: request to reindex %s failed: %d:%s", md.PID, putErr.StatusCode, putErr.Message)
-		} else {
-			log.Printf("INFO: %s was successfully queued for reindex; update dates", md.PID)
-			now := time.Now()
-			md.DateDLUpdate = &now
-			err = svc.DB.Model(&md).Select("DateDLUpdate").Updates(md).Error
-			if err != nil {
-				log.Printf("ERROR: update xml publish date for %s failed: %s", md.PID, err.Error())
-			}
-		}
-	}
+	svc.reindexXMLMetadata(&md)
 
 	resp := struct {
 		DescMetadata string `json:"metadata"`
@@ -943,6 +943,30 @@ func (svc *serviceContext) uploadXMLMetadata(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// reindexXMLMetadata calls the xml reindexing hook for previously published metadata so
+// changes to the descriptive metadata are reflected in the DL. Failures are logged here, so callers
+// that only need a best effort reindex can ignore the returned error.
+func (svc *serviceContext) reindexXMLMetadata(md *metadata) error {
+	if md.DateDLIngest == nil && md.DateDLUpdate == nil {
+		return nil
+	}
+	log.Printf("INFO: call xml reindexing hook for previously published metadata %s", md.PID)
+	_, putErr := svc.putRequest(fmt.Sprintf("%s/%d", svc.ExternalSystems.XMLIndex, md.ID))
+	if putErr != nil {
+		log.Printf("ERROR: request to reindex %s failed: %d:%s", md.PID, putErr.StatusCode, putErr.Message)
+		return fmt.Errorf("reindex request failed: %d:%s", putErr.StatusCode, putErr.Message)
+	}
+	log.Printf("INFO: %s was successfully queued for reindex; update dates", md.PID)
+	now := time.Now()
+	md.DateDLUpdate = &now
+	err := svc.DB.Model(md).Select("DateDLUpdate").Updates(*md).Error
+	if err != nil {
+		log.Printf("ERROR: update xml publish date for %s failed: %s", md.PID, err.Error())
+		return fmt.Errorf("unable to update publish date: %s", err.Error())
+	}
+	return nil
+}
+
 func parseModsTitle(modsBytes []byte) (string, error) {
 
 	type modsTitle struct {
@@ -967,6 +991,9 @@ func parseModsTitle(modsBytes []byte) (string, error) {
 	}
 
 	log.Printf("%+v", mods)
+	if len(mods.TitleInfo) == 0 {
+		return "", fmt.Errorf("mods does not contain a titleInfo")
+	}
 
 	return mods.TitleInfo[0].Title.Value, nil
 }
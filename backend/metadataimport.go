@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// importRow is one row of a metadata import spreadsheet along with its validation result
+type importRow struct {
+	Row      int
+	Values   map[string]string
+	Metadata metadata
+	UseRight int64
+	Status   string // valid, error or created
+	Message  string
+}
+
+// column names accepted in the import header; matched case-insensitively and ignoring spaces and underscores
+var importColumns = []string{"type", "barcode", "catkey", "title", "author", "availability", "useright",
+	"ocrhint", "ocrlanguage", "collection", "collectionfacet", "dpla", "manuscript", "personal"}
+
+type importVocabulary struct {
+	availability map[string]int64
+	useRights    map[string]int64
+	ocrHints     map[string]int64
+	facets       map[string]string
+}
+
+// importMetadata creates metadata records from a CSV file. Every row is validated before anything is
+// created; if any row fails, nothing is created. The response is a CSV with the result of each row.
+func (svc *serviceContext) importMetadata(c *gin.Context) {
+	dryRun := c.Query("dryrun") == "true"
+	var csvData []byte
+	formFile, err := c.FormFile("file")
+	if err == nil {
+		f, openErr := formFile.Open()
+		if openErr != nil {
+			log.Printf("ERROR: unable to open metadata import file %s: %s", formFile.Filename, openErr.Error())
+			c.String(http.StatusBadRequest, openErr.Error())
+			return
+		}
+		csvData, err = io.ReadAll(f)
+		f.Close()
+	} else {
+		csvData, err = io.ReadAll(c.Request.Body)
+	}
+	if err != nil {
+		log.Printf("ERROR: unable to read metadata import file: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := parseImportCSV(csvData)
+	if err != nil {
+		log.Printf("ERROR: invalid metadata import file: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("INFO: validate %d rows of metadata import; dry run %t", len(rows), dryRun)
+
+	vocab, err := svc.loadImportVocabulary()
+	if err != nil {
+		log.Printf("ERROR: unable to load controlled vocabularies for metadata import: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	errorCnt := 0
+	seen := make(map[string]int)
+	for _, row := range rows {
+		svc.validateImportRow(row, vocab)
+		if row.Status == "valid" && row.Metadata.Type == "SirsiMetadata" {
+			key := fmt.Sprintf("%s|%s", *row.Metadata.CatalogKey, *row.Metadata.Barcode)
+			if prior, found := seen[key]; found {
+				row.Status = "error"
+				row.Message = fmt.Sprintf("duplicate of row %d", prior)
+			} else {
+				seen[key] = row.Row
+			}
+		}
+		if row.Status == "error" {
+			errorCnt++
+		}
+	}
+
+	status := http.StatusOK
+	if errorCnt > 0 {
+		log.Printf("INFO: metadata import has %d invalid rows; nothing created", errorCnt)
+		status = http.StatusUnprocessableEntity
+	} else if dryRun == false {
+		err = svc.DB.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				if err := tx.Create(&row.Metadata).Error; err != nil {
+					return fmt.Errorf("row %d: %s", row.Row, err.Error())
+				}
+				// the pid is set by the AfterCreate hook directly in the DB
+				row.Metadata.PID = fmt.Sprintf("tsb:%d", row.Metadata.ID)
+				row.Status = "created"
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: metadata import failed: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("INFO: metadata import created %d records", len(rows))
+
+		// as with createMetadata, send rights other than CNE or UND to sirsi
+		for _, row := range rows {
+			if row.Metadata.Type == "SirsiMetadata" && row.UseRight > 0 && row.UseRight != 1 && row.UseRight != 11 {
+				svc.sendUseRightToSirsi(&row.Metadata, row.UseRight)
+			}
+		}
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=metadata-import-results.csv")
+	c.Status(status)
+	cw := csv.NewWriter(c.Writer)
+	cw.Write([]string{"row", "status", "message", "id", "pid", "type", "title", "catkey", "barcode", "call number"})
+	for _, row := range rows {
+		md := row.Metadata
+		line := []string{fmt.Sprintf("%d", row.Row), row.Status, row.Message, "", md.PID, md.Type, md.Title,
+			stringValue(md.CatalogKey), stringValue(md.Barcode), stringValue(md.CallNumber)}
+		if md.ID > 0 {
+			line[3] = fmt.Sprintf("%d", md.ID)
+		}
+		cw.Write(line)
+	}
+	cw.Flush()
+}
+
+func parseImportCSV(data []byte) ([]*importRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // spreadsheet exports often include a BOM
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("import file must have a header and at least one row")
+	}
+
+	header := make([]string, len(records[0]))
+	for idx, col := range records[0] {
+		name := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(col)))
+		if name == "catalogkey" || name == "ckey" {
+			name = "catkey"
+		}
+		if name == "creator" {
+			name = "author"
+		}
+		if slices.Contains(importColumns, name) == false {
+			return nil, fmt.Errorf("unsupported column %s", col)
+		}
+		header[idx] = name
+	}
+
+	out := make([]*importRow, 0)
+	for rowIdx, rec := range records[1:] {
+		row := importRow{Row: rowIdx + 1, Values: make(map[string]string)}
+		blank := true
+		for idx, val := range rec {
+			if idx < len(header) {
+				row.Values[header[idx]] = strings.TrimSpace(val)
+				if strings.TrimSpace(val) != "" {
+					blank = false
+				}
+			}
+		}
+		if blank == false {
+			out = append(out, &row)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("import file has no data rows")
+	}
+	return out, nil
+}
+
+func stringValue(val *string) string {
+	if val == nil {
+		return ""
+	}
+	return *val
+}
+
+func (svc *serviceContext) loadImportVocabulary() (*importVocabulary, error) {
+	vocab := importVocabulary{availability: make(map[string]int64), useRights: make(map[string]int64),
+		ocrHints: make(map[string]int64), facets: make(map[string]string)}
+
+	var policies []availabilityPolicy
+	if err := svc.DB.Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		vocab.availability[strings.ToLower(p.Name)] = p.ID
+		vocab.availability[fmt.Sprintf("%d", p.ID)] = p.ID
+	}
+
+	var rights []useRight
+	if err := svc.DB.Find(&rights).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rights {
+		vocab.useRights[strings.ToLower(r.Name)] = int64(r.ID)
+		vocab.useRights[fmt.Sprintf("%d", r.ID)] = int64(r.ID)
+	}
+
+	var hints []ocrHint
+	if err := svc.DB.Find(&hints).Error; err != nil {
+		return nil, err
+	}
+	for _, h := range hints {
+		vocab.ocrHints[strings.ToLower(h.Name)] = int64(h.ID)
+		vocab.ocrHints[fmt.Sprintf("%d", h.ID)] = int64(h.ID)
+	}
+
+	var facets []collectionFacet
+	if err := svc.DB.Find(&facets).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range facets {
+		vocab.facets[strings.ToLower(f.Name)] = f.Name
+	}
+	return &vocab, nil
+}
+
+// validateImportRow converts a row into a new metadata record, flagging the row as an error if it is invalid
+func (svc *serviceContext) validateImportRow(row *importRow, vocab *importVocabulary) {
+	row.Status = "error"
+	vals := row.Values
+	createTime := time.Now()
+	md := metadata{Title: vals["title"], CreatedAt: &createTime,
+		DPLA: parseImportBool(vals["dpla"]), IsManuscript: parseImportBool(vals["manuscript"]), IsPersonalItem: parseImportBool(vals["personal"])}
+
+	mdType := strings.ToLower(vals["type"])
+	switch mdType {
+	case "sirsi", "sirsimetadata":
+		md.Type = "SirsiMetadata"
+	case "xml", "xmlmetadata":
+		md.Type = "XmlMetadata"
+	case "":
+		md.Type = "XmlMetadata"
+		if vals["barcode"] != "" || vals["catkey"] != "" {
+			md.Type = "SirsiMetadata"
+		}
+	default:
+		row.Message = fmt.Sprintf("unsupported type %s", vals["type"])
+		row.Metadata = md
+		return
+	}
+	row.Metadata = md
+
+	if val := vals["availability"]; val != "" {
+		policyID, ok := vocab.availability[strings.ToLower(val)]
+		if ok == false {
+			row.Message = fmt.Sprintf("unknown availability policy %s", val)
+			return
+		}
+		row.Metadata.AvailabilityPolicyID = &policyID
+	}
+	if val := vals["useright"]; val != "" {
+		rightID, ok := vocab.useRights[strings.ToLower(val)]
+		if ok == false {
+			row.Message = fmt.Sprintf("unknown use right %s", val)
+			return
+		}
+		row.UseRight = rightID
+		if row.Metadata.Type != "SirsiMetadata" {
+			row.Metadata.UseRightID = &rightID
+		}
+	}
+	if val := vals["ocrhint"]; val != "" {
+		hintID, ok := vocab.ocrHints[strings.ToLower(val)]
+		if ok == false {
+			row.Message = fmt.Sprintf("unknown OCR hint %s", val)
+			return
+		}
+		row.Metadata.OCRHintID = &hintID
+		if hintID == 1 {
+			row.Metadata.OCRLanguageHint = vals["ocrlanguage"]
+		}
+	}
+	if val := vals["collectionfacet"]; val != "" {
+		facet, ok := vocab.facets[strings.ToLower(val)]
+		if ok == false {
+			row.Message = fmt.Sprintf("unknown collection facet %s", val)
+			return
+		}
+		row.Metadata.CollectionFacet = &facet
+	}
+	if val := vals["collection"]; val != "" {
+		var coll metadata
+		collQ := svc.DB.Select("id", "is_collection")
+		if collID, _ := strconv.ParseInt(val, 10, 64); collID > 0 {
+			collQ = collQ.Where("id=?", collID)
+		} else {
+			collQ = collQ.Where("pid=?", val)
+		}
+		if err := collQ.Limit(1).Find(&coll).Error; err != nil || coll.ID == 0 || coll.IsCollection == false {
+			row.Message = fmt.Sprintf("%s is not a collection", val)
+			return
+		}
+		row.Metadata.ParentMetadataID = coll.ID
+	}
+
+	if row.Metadata.Type == "XmlMetadata" {
+		if row.Metadata.Title == "" {
+			row.Message = "title is required for XML metadata"
+			return
+		}
+		xmlMD := newMODSRecord(row.Metadata.Title, vals["author"])
+		row.Metadata.DescMetadata = &xmlMD
+		if vals["author"] != "" {
+			author := vals["author"]
+			row.Metadata.CreatorName = &author
+		}
+		row.Status = "valid"
+		return
+	}
+
+	barcode := strings.ToUpper(vals["barcode"])
+	catKey := strings.ToLower(vals["catkey"])
+	if barcode == "" && catKey == "" {
+		row.Message = "barcode or catkey is required for sirsi metadata"
+		return
+	}
+	sirsiResp, _, err := svc.cachedSirsiLookup(catKey, barcode, false)
+	if err != nil {
+		row.Message = fmt.Sprintf("sirsi lookup failed: %s", err.Error())
+		return
+	}
+	row.Metadata.Barcode = &sirsiResp.Barcode
+	row.Metadata.CatalogKey = &sirsiResp.CatalogKey
+	row.Metadata.CallNumber = &sirsiResp.CallNumber
+	row.Metadata.CollectionID = &sirsiResp.CollectionID
+	if row.Metadata.Title == "" {
+		row.Metadata.Title = sirsiResp.Title
+	}
+	author := vals["author"]
+	if author == "" {
+		author = sirsiResp.CreatorName
+	}
+	if author != "" {
+		row.Metadata.CreatorName = &author
+	}
+
+	var existMD metadata
+	err = svc.DB.Select("id", "pid").Where("barcode=? and catalog_key=?", sirsiResp.Barcode, sirsiResp.CatalogKey).Limit(1).Find(&existMD).Error
+	if err != nil {
+		row.Message = fmt.Sprintf("duplicate check failed: %s", err.Error())
+		return
+	}
+	if existMD.ID > 0 {
+		row.Message = fmt.Sprintf("metadata already exists as %s", existMD.PID)
+		return
+	}
+	row.Status = "valid"
+}
+
+func parseImportBool(val string) bool {
+	val = strings.ToLower(strings.TrimSpace(val))
+	return val == "true" || val == "yes" || val == "y" || val == "1" || val == "x"
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modsNode is a generic, lossless representation of a node in a MODS document. Elements
+// retain their raw namespace prefix and attributes so that anything not understood by the
+// structured editor is written back exactly as it was read.
+type modsNode struct {
+	Name     xml.Name // Space holds the raw prefix, not the namespace URI
+	Attrs    []xml.Attr
+	Text     string // set for text nodes and comments
+	Comment  bool
+	Children []*modsNode
+}
+
+type modsDocument struct {
+	Prolog []string // processing instructions, comments and directives before the root
+	Root   *modsNode
+}
+
+type modsValue struct {
+	Ref       int    `json:"ref"` // 1-based position of the source element; 0 for new entries
+	Value     string `json:"value"`
+	Type      string `json:"type,omitempty"`
+	Authority string `json:"authority,omitempty"`
+}
+
+type modsTitle struct {
+	Ref        int    `json:"ref"`
+	Type       string `json:"type,omitempty"`
+	NonSort    string `json:"nonSort,omitempty"`
+	Title      string `json:"title"`
+	SubTitle   string `json:"subTitle,omitempty"`
+	PartNumber string `json:"partNumber,omitempty"`
+	PartName   string `json:"partName,omitempty"`
+}
+
+type modsNamePart struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+type modsRole struct {
+	Value     string `json:"value"`
+	Type      string `json:"type,omitempty"`
+	Authority string `json:"authority,omitempty"`
+}
+
+type modsName struct {
+	Ref       int            `json:"ref"`
+	Type      string         `json:"type,omitempty"`
+	NameParts []modsNamePart `json:"nameParts"`
+	Roles     []modsRole     `json:"roles"`
+}
+
+type modsDate struct {
+	Ref       int    `json:"ref"`
+	Element   string `json:"element"` // dateIssued, dateCreated, copyrightDate, ...
+	Value     string `json:"value"`
+	Encoding  string `json:"encoding,omitempty"`
+	Point     string `json:"point,omitempty"`
+	Qualifier string `json:"qualifier,omitempty"`
+	KeyDate   bool   `json:"keyDate"`
+}
+
+type modsSubjectTerm struct {
+	Type  string `json:"type"` // topic, geographic, temporal, genre, occupation or name
+	Value string `json:"value"`
+}
+
+type modsSubject struct {
+	Ref       int               `json:"ref"`
+	Authority string            `json:"authority,omitempty"`
+	Terms     []modsSubjectTerm `json:"terms"`
+}
+
+type modsPhysicalDescription struct {
+	Extents []modsValue `json:"extents"`
+	Forms   []modsValue `json:"forms"`
+}
+
+type modsFields struct {
+	Titles              []modsTitle             `json:"titles"`
+	Names               []modsName              `json:"names"`
+	Dates               []modsDate              `json:"dates"`
+	Subjects            []modsSubject           `json:"subjects"`
+	Genres              []modsValue             `json:"genres"`
+	PhysicalDescription modsPhysicalDescription `json:"physicalDescription"`
+	Notes               []modsValue             `json:"notes"`
+	Rights              []modsValue             `json:"rights"`
+}
+
+var modsDateElements = []string{"dateIssued", "dateCreated", "copyrightDate", "dateCaptured", "dateValid", "dateModified", "dateOther"}
+var modsSubjectTerms = []string{"topic", "geographic", "temporal", "genre", "occupation", "name"}
+
+// xml.EscapeText also escapes newlines, which would destroy the formatting of untouched content
+var modsTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+var modsAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+
+func (svc *serviceContext) getMODSFields(c *gin.Context) {
+	md, err := svc.loadXMLMetadataRecord(c.Param("id"))
+	if err != nil {
+		log.Printf("ERROR: unable to load xml metadata %s for mods fields: %s", c.Param("id"), err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Printf("INFO: parse mods fields for metadata %d", md.ID)
+	doc, err := parseMODSDocument(*md.DescMetadata)
+	if err != nil {
+		log.Printf("ERROR: unable to parse mods for metadata %d: %s", md.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, doc.fields())
+}
+
+func (svc *serviceContext) updateMODSFields(c *gin.Context) {
+	md, err := svc.loadXMLMetadataRecord(c.Param("id"))
+	if err != nil {
+		log.Printf("ERROR: unable to load xml metadata %s for mods update: %s", c.Param("id"), err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var req modsFields
+	err = c.BindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid mods fields update request for metadata %d: %s", md.ID, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(req.Titles) == 0 || strings.TrimSpace(req.Titles[0].Title) == "" {
+		log.Printf("INFO: mods fields update for metadata %d is missing a title", md.ID)
+		c.String(http.StatusBadRequest, "a title is required")
+		return
+	}
+
+	doc, err := parseMODSDocument(*md.DescMetadata)
+	if err != nil {
+		log.Printf("ERROR: unable to parse mods for metadata %d: %s", md.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("INFO: apply mods field updates to metadata %d", md.ID)
+	doc.applyFields(&req)
+	descMetadata := doc.String()
+
+	// make sure the generated document is well formed and still has a title before saving it
+	if _, err := parseMODSDocument(descMetadata); err != nil {
+		log.Printf("ERROR: updated mods for metadata %d is malformed: %s", md.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	modsTitle, err := parseModsTitle([]byte(descMetadata))
+	if err != nil {
+		log.Printf("ERROR: updated mods for metadata %d has no title: %s", md.ID, err.Error())
+		c.String(http.StatusBadRequest, "a title is required")
+		return
+	}
+
+	md.DescMetadata = &descMetadata
+	md.Title = modsTitle
+	err = svc.DB.Model(md).Select("DescMetadata", "Title").Updates(*md).Error
+	if err != nil {
+		log.Printf("ERROR: update mods fields for metadata %d failed: %s", md.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	svc.reindexXMLMetadata(md)
+
+	resp := struct {
+		Fields       modsFields `json:"fields"`
+		DescMetadata string     `json:"metadata"`
+		Title        string     `json:"title"`
+	}{
+		Fields:       doc.fields(),
+		DescMetadata: descMetadata,
+		Title:        md.Title,
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (svc *serviceContext) loadXMLMetadataRecord(idStr string) (*metadata, error) {
+	mdID, _ := strconv.ParseInt(idStr, 10, 64)
+	if mdID == 0 {
+		return nil, fmt.Errorf("invalid id %s", idStr)
+	}
+	var md metadata
+	err := svc.DB.Limit(1).Find(&md, mdID).Error
+	if err != nil {
+		return nil, err
+	}
+	if md.ID == 0 {
+		return nil, fmt.Errorf("metadata %d not found", mdID)
+	}
+	if md.Type != "XmlMetadata" || md.DescMetadata == nil {
+		return nil, fmt.Errorf("%d is not an XML metadata record", md.ID)
+	}
+	return &md, nil
+}
+
+func parseMODSDocument(raw string) (*modsDocument, error) {
+	doc := modsDocument{}
+	dec := xml.NewDecoder(strings.NewReader(raw))
+	var stack []*modsNode
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var parent *modsNode
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &modsNode{Name: t.Name, Attrs: append([]xml.Attr{}, t.Attr...)}
+			if parent == nil {
+				if doc.Root != nil {
+					return nil, errors.New("mods document has more than one root element")
+				}
+				doc.Root = node
+			} else {
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if parent == nil || parent.Name != t.Name {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if parent != nil {
+				parent.Children = append(parent.Children, &modsNode{Text: string(t)})
+			}
+		case xml.Comment:
+			if parent != nil {
+				parent.Children = append(parent.Children, &modsNode{Text: string(t), Comment: true})
+			} else {
+				doc.Prolog = append(doc.Prolog, fmt.Sprintf("<!--%s-->", t))
+			}
+		case xml.ProcInst:
+			if parent == nil {
+				doc.Prolog = append(doc.Prolog, fmt.Sprintf("<?%s %s?>", t.Target, t.Inst))
+			}
+		case xml.Directive:
+			if parent == nil {
+				doc.Prolog = append(doc.Prolog, fmt.Sprintf("<!%s>", t))
+			}
+		}
+	}
+
+	if doc.Root == nil || doc.Root.Name.Local != "mods" {
+		return nil, errors.New("document does not contain a mods root element")
+	}
+	if len(stack) > 0 {
+		return nil, errors.New("mods document is truncated")
+	}
+	return &doc, nil
+}
+
+func (doc *modsDocument) String() string {
+	var sb strings.Builder
+	for _, p := range doc.Prolog {
+		sb.WriteString(p)
+		sb.WriteString("\n")
+	}
+	doc.Root.write(&sb)
+	return sb.String()
+}
+
+func (n *modsNode) isElement() bool {
+	return n.Name.Local != ""
+}
+
+func (n *modsNode) write(sb *strings.Builder) {
+	if n.isElement() == false {
+		if n.Comment {
+			sb.WriteString("<!--" + n.Text + "-->")
+		} else {
+			sb.WriteString(modsTextEscaper.Replace(n.Text))
+		}
+		return
+	}
+	sb.WriteString("<" + qualifiedName(n.Name))
+	for _, a := range n.Attrs {
+		sb.WriteString(" " + qualifiedName(a.Name) + "=\"" + modsAttrEscaper.Replace(a.Value) + "\"")
+	}
+	if len(n.Children) == 0 {
+		sb.WriteString("/>")
+		return
+	}
+	sb.WriteString(">")
+	for _, child := range n.Children {
+		child.write(sb)
+	}
+	sb.WriteString("</" + qualifiedName(n.Name) + ">")
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return fmt.Sprintf("%s:%s", name.Space, name.Local)
+}
+
+func (n *modsNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// setAttr adds or replaces an attribute. An empty value removes it.
+func (n *modsNode) setAttr(name, value string) {
+	for idx, a := range n.Attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			if value == "" {
+				n.Attrs = append(n.Attrs[:idx], n.Attrs[idx+1:]...)
+			} else {
+				n.Attrs[idx].Value = value
+			}
+			return
+		}
+	}
+	if value != "" {
+		n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+}
+
+func (n *modsNode) text() string {
+	var sb strings.Builder
+	for _, child := range n.Children {
+		if child.isElement() == false && child.Comment == false {
+			sb.WriteString(child.Text)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func (n *modsNode) setText(value string) {
+	kept := make([]*modsNode, 0, len(n.Children))
+	for _, child := range n.Children {
+		if child.isElement() || child.Comment {
+			kept = append(kept, child)
+		}
+	}
+	n.Children = append([]*modsNode{{Text: value}}, kept...)
+}
+
+func (n *modsNode) elements(names ...string) []*modsNode {
+	out := make([]*modsNode, 0)
+	for _, child := range n.Children {
+		if child.isElement() && matchesName(child, names) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+func (n *modsNode) firstText(name string) string {
+	for _, child := range n.elements(name) {
+		return child.text()
+	}
+	return ""
+}
+
+func (n *modsNode) hasElements() bool {
+	for _, child := range n.Children {
+		if child.isElement() {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesName(n *modsNode, names []string) bool {
+	for _, name := range names {
+		if n.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *modsNode) clone() *modsNode {
+	out := &modsNode{Name: n.Name, Attrs: append([]xml.Attr{}, n.Attrs...), Text: n.Text, Comment: n.Comment}
+	for _, child := range n.Children {
+		out.Children = append(out.Children, child.clone())
+	}
+	return out
+}
+
+// indent returns the whitespace used in front of the child elements of this node. Nodes
+// that already have compact (unindented) children stay compact.
+func (n *modsNode) indent(depth int) string {
+	for idx, child := range n.Children {
+		if child.isElement() == false && child.Comment == false && strings.TrimSpace(child.Text) == "" &&
+			idx+1 < len(n.Children) && n.Children[idx+1].isElement() {
+			return child.Text
+		}
+	}
+	if n.hasElements() {
+		return ""
+	}
+	return "\n" + strings.Repeat("   ", depth)
+}
+
+// replaceChildren removes every child element accepted by match, along with the whitespace in front of it,
+// and inserts the replacements where the first removed element was found (or at the end if none were).
+func (n *modsNode) replaceChildren(match func(*modsNode) bool, replacements []*modsNode, depth int) {
+	indent := n.indent(depth)
+	insertAt := -1
+	kept := make([]*modsNode, 0, len(n.Children))
+	for _, child := range n.Children {
+		if child.isElement() && match(child) {
+			if len(kept) > 0 {
+				prior := kept[len(kept)-1]
+				if prior.isElement() == false && prior.Comment == false && strings.TrimSpace(prior.Text) == "" {
+					kept = kept[:len(kept)-1]
+				}
+			}
+			if insertAt == -1 {
+				insertAt = len(kept)
+			}
+			continue
+		}
+		kept = append(kept, child)
+	}
+
+	if insertAt == -1 {
+		// append after the last element so the trailing whitespace before the close tag is preserved
+		insertAt = len(kept)
+		for insertAt > 0 && kept[insertAt-1].isElement() == false && strings.TrimSpace(kept[insertAt-1].Text) == "" {
+			insertAt--
+		}
+		if len(kept) == 0 && len(replacements) > 0 {
+			kept = append(kept, &modsNode{Text: indent[:max(1, len(indent)-3)]})
+		}
+	}
+
+	added := make([]*modsNode, 0, len(replacements)*2)
+	for _, r := range replacements {
+		if indent != "" {
+			added = append(added, &modsNode{Text: indent})
+		}
+		added = append(added, r)
+	}
+	out := make([]*modsNode, 0, len(kept)+len(added))
+	out = append(out, kept[:insertAt]...)
+	out = append(out, added...)
+	out = append(out, kept[insertAt:]...)
+	n.Children = out
+}
+
+func (doc *modsDocument) newElement(name string) *modsNode {
+	return &modsNode{Name: xml.Name{Space: doc.Root.Name.Space, Local: name}}
+}
+
+func (doc *modsDocument) newTextElement(name, value string) *modsNode {
+	el := doc.newElement(name)
+	el.setText(value)
+	return el
+}
+
+// sourceElement returns a copy of the original element referenced by a 1-based ref, or a new element
+func (doc *modsDocument) sourceElement(originals []*modsNode, ref int, name string) *modsNode {
+	if ref > 0 && ref <= len(originals) {
+		return originals[ref-1].clone()
+	}
+	return doc.newElement(name)
+}
+
+func (doc *modsDocument) titleInfos() []*modsNode {
+	return doc.Root.elements("titleInfo")
+}
+
+func (doc *modsDocument) names() []*modsNode {
+	return doc.Root.elements("name")
+}
+
+func (doc *modsDocument) subjects() []*modsNode {
+	out := make([]*modsNode, 0)
+	for _, s := range doc.Root.elements("subject") {
+		if isSimpleSubject(s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// isSimpleSubject is true for subjects made up entirely of terms supported by the editor. Complex subjects,
+// such as those with hierarchicalGeographic or cartographics, are left untouched.
+func isSimpleSubject(s *modsNode) bool {
+	for _, child := range s.Children {
+		if child.isElement() && matchesName(child, modsSubjectTerms) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func (doc *modsDocument) dates() []*modsNode {
+	out := make([]*modsNode, 0)
+	for _, oi := range doc.Root.elements("originInfo") {
+		out = append(out, oi.elements(modsDateElements...)...)
+	}
+	return out
+}
+
+func (doc *modsDocument) physicalDescriptionElements(name string) []*modsNode {
+	out := make([]*modsNode, 0)
+	for _, pd := range doc.Root.elements("physicalDescription") {
+		out = append(out, pd.elements(name)...)
+	}
+	return out
+}
+
+func toModsValues(nodes []*modsNode) []modsValue {
+	out := make([]modsValue, 0, len(nodes))
+	for idx, n := range nodes {
+		out = append(out, modsValue{Ref: idx + 1, Value: n.text(), Type: n.attr("type"), Authority: n.attr("authority")})
+	}
+	return out
+}
+
+func (doc *modsDocument) fields() modsFields {
+	out := modsFields{}
+
+	out.Titles = make([]modsTitle, 0)
+	for idx, ti := range doc.titleInfos() {
+		out.Titles = append(out.Titles, modsTitle{Ref: idx + 1, Type: ti.attr("type"),
+			NonSort: ti.firstText("nonSort"), Title: ti.firstText("title"), SubTitle: ti.firstText("subTitle"),
+			PartNumber: ti.firstText("partNumber"), PartName: ti.firstText("partName")})
+	}
+
+	out.Names = make([]modsName, 0)
+	for idx, n := range doc.names() {
+		name := modsName{Ref: idx + 1, Type: n.attr("type"), NameParts: make([]modsNamePart, 0), Roles: make([]modsRole, 0)}
+		for _, np := range n.elements("namePart") {
+			name.NameParts = append(name.NameParts, modsNamePart{Type: np.attr("type"), Value: np.text()})
+		}
+		for _, r := range n.elements("role") {
+			for _, rt := range r.elements("roleTerm") {
+				name.Roles = append(name.Roles, modsRole{Value: rt.text(), Type: rt.attr("type"), Authority: rt.attr("authority")})
+			}
+		}
+		out.Names = append(out.Names, name)
+	}
+
+	out.Dates = make([]modsDate, 0)
+	for idx, d := range doc.dates() {
+		out.Dates = append(out.Dates, modsDate{Ref: idx + 1, Element: d.Name.Local, Value: d.text(),
+			Encoding: d.attr("encoding"), Point: d.attr("point"), Qualifier: d.attr("qualifier"), KeyDate: d.attr("keyDate") == "yes"})
+	}
+
+	out.Subjects = make([]modsSubject, 0)
+	for idx, s := range doc.subjects() {
+		subj := modsSubject{Ref: idx + 1, Authority: s.attr("authority"), Terms: make([]modsSubjectTerm, 0)}
+		for _, t := range s.elements(modsSubjectTerms...) {
+			val := t.text()
+			if t.Name.Local == "name" {
+				parts := make([]string, 0)
+				for _, np := range t.elements("namePart") {
+					parts = append(parts, np.text())
+				}
+				val = strings.Join(parts, ", ")
+			}
+			subj.Terms = append(subj.Terms, modsSubjectTerm{Type: t.Name.Local, Value: val})
+		}
+		out.Subjects = append(out.Subjects, subj)
+	}
+
+	out.Genres = toModsValues(doc.Root.elements("genre"))
+	out.PhysicalDescription.Extents = toModsValues(doc.physicalDescriptionElements("extent"))
+	out.PhysicalDescription.Forms = toModsValues(doc.physicalDescriptionElements("form"))
+	out.Notes = toModsValues(doc.Root.elements("note"))
+	out.Rights = toModsValues(doc.Root.elements("accessCondition"))
+	return out
+}
+
+// applyFields replaces the structured portions of the document with the supplied fields. Entries with a ref
+// start from a copy of the original element, so attributes and child elements the editor does not model are kept.
+func (doc *modsDocument) applyFields(f *modsFields) {
+	root := doc.Root
+
+	origTitles := doc.titleInfos()
+	titles := make([]*modsNode, 0, len(f.Titles))
+	for _, t := range f.Titles {
+		el := doc.sourceElement(origTitles, t.Ref, "titleInfo")
+		el.setAttr("type", t.Type)
+		parts := make([]*modsNode, 0)
+		for _, p := range []struct{ name, val string }{{"nonSort", t.NonSort}, {"title", t.Title}, {"subTitle", t.SubTitle},
+			{"partNumber", t.PartNumber}, {"partName", t.PartName}} {
+			if p.val != "" {
+				parts = append(parts, doc.newTextElement(p.name, p.val))
+			}
+		}
+		el.replaceChildren(func(n *modsNode) bool {
+			return matchesName(n, []string{"nonSort", "title", "subTitle", "partNumber", "partName"})
+		}, parts, 2)
+		titles = append(titles, el)
+	}
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "titleInfo" }, titles, 1)
+
+	origNames := doc.names()
+	names := make([]*modsNode, 0, len(f.Names))
+	for _, n := range f.Names {
+		el := doc.sourceElement(origNames, n.Ref, "name")
+		el.setAttr("type", n.Type)
+		parts := make([]*modsNode, 0)
+		for _, np := range n.NameParts {
+			part := doc.newTextElement("namePart", np.Value)
+			part.setAttr("type", np.Type)
+			parts = append(parts, part)
+		}
+		el.replaceChildren(func(c *modsNode) bool { return c.Name.Local == "namePart" }, parts, 2)
+		roles := make([]*modsNode, 0)
+		for _, r := range n.Roles {
+			role := doc.newElement("role")
+			term := doc.newTextElement("roleTerm", r.Value)
+			term.setAttr("type", r.Type)
+			term.setAttr("authority", r.Authority)
+			role.replaceChildren(func(*modsNode) bool { return false }, []*modsNode{term}, 3)
+			roles = append(roles, role)
+		}
+		el.replaceChildren(func(c *modsNode) bool { return c.Name.Local == "role" }, roles, 2)
+		names = append(names, el)
+	}
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "name" }, names, 1)
+
+	origDates := doc.dates()
+	dates := make([]*modsNode, 0, len(f.Dates))
+	dateContainers := make([]int, 0, len(f.Dates))
+	for _, d := range f.Dates {
+		elName := d.Element
+		if matchesName(&modsNode{Name: xml.Name{Local: elName}}, modsDateElements) == false {
+			elName = "dateCreated"
+		}
+		el := doc.sourceElement(origDates, d.Ref, elName)
+		el.Name.Local = elName
+		el.setText(d.Value)
+		el.setAttr("encoding", d.Encoding)
+		el.setAttr("point", d.Point)
+		el.setAttr("qualifier", d.Qualifier)
+		el.setAttr("keyDate", "")
+		if d.KeyDate {
+			el.setAttr("keyDate", "yes")
+		}
+		dates = append(dates, el)
+		dateContainers = append(dateContainers, doc.containerIndex("originInfo", modsDateElements, d.Ref))
+	}
+	doc.replaceContainedElements("originInfo", modsDateElements, dates, dateContainers)
+
+	origSubjects := doc.subjects()
+	subjects := make([]*modsNode, 0, len(f.Subjects))
+	for _, s := range f.Subjects {
+		el := doc.sourceElement(origSubjects, s.Ref, "subject")
+		el.setAttr("authority", s.Authority)
+		terms := make([]*modsNode, 0)
+		for _, t := range s.Terms {
+			if matchesName(&modsNode{Name: xml.Name{Local: t.Type}}, modsSubjectTerms) == false {
+				t.Type = "topic"
+			}
+			if t.Type == "name" {
+				term := doc.newElement("name")
+				term.replaceChildren(func(*modsNode) bool { return false }, []*modsNode{doc.newTextElement("namePart", t.Value)}, 3)
+				terms = append(terms, term)
+			} else {
+				terms = append(terms, doc.newTextElement(t.Type, t.Value))
+			}
+		}
+		el.replaceChildren(func(c *modsNode) bool { return matchesName(c, modsSubjectTerms) }, terms, 2)
+		subjects = append(subjects, el)
+	}
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "subject" && isSimpleSubject(n) }, subjects, 1)
+
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "genre" },
+		doc.valueElements(root.elements("genre"), f.Genres, "genre"), 1)
+
+	extents := doc.valueElements(doc.physicalDescriptionElements("extent"), f.PhysicalDescription.Extents, "extent")
+	forms := doc.valueElements(doc.physicalDescriptionElements("form"), f.PhysicalDescription.Forms, "form")
+	pdContainers := make([]int, 0, len(forms)+len(extents))
+	for _, v := range f.PhysicalDescription.Forms {
+		pdContainers = append(pdContainers, doc.containerIndex("physicalDescription", []string{"form"}, v.Ref))
+	}
+	for _, v := range f.PhysicalDescription.Extents {
+		pdContainers = append(pdContainers, doc.containerIndex("physicalDescription", []string{"extent"}, v.Ref))
+	}
+	doc.replaceContainedElements("physicalDescription", []string{"form", "extent"}, append(forms, extents...), pdContainers)
+
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "note" },
+		doc.valueElements(root.elements("note"), f.Notes, "note"), 1)
+	root.replaceChildren(func(n *modsNode) bool { return n.Name.Local == "accessCondition" },
+		doc.valueElements(root.elements("accessCondition"), f.Rights, "accessCondition"), 1)
+}
+
+func (doc *modsDocument) valueElements(originals []*modsNode, values []modsValue, name string) []*modsNode {
+	out := make([]*modsNode, 0, len(values))
+	for _, v := range values {
+		el := doc.sourceElement(originals, v.Ref, name)
+		el.setText(v.Value)
+		el.setAttr("type", v.Type)
+		el.setAttr("authority", v.Authority)
+		out = append(out, el)
+	}
+	return out
+}
+
+// containerIndex returns the position of the container (originInfo, physicalDescription) holding the original
+// element referenced by a 1-based ref among the named children of every container. New elements use the first.
+func (doc *modsDocument) containerIndex(containerName string, childNames []string, ref int) int {
+	for idx, container := range doc.Root.elements(containerName) {
+		count := len(container.elements(childNames...))
+		if ref <= count {
+			return idx
+		}
+		ref -= count
+	}
+	return 0
+}
+
+// replaceContainedElements swaps the named children of every container element (originInfo, physicalDescription)
+// for the replacements. Each replacement is placed in the container at the matching position of containerIdx so
+// edited elements stay where they were found; containers are kept even if they are left empty.
+func (doc *modsDocument) replaceContainedElements(containerName string, childNames []string, replacements []*modsNode, containerIdx []int) {
+	containers := doc.Root.elements(containerName)
+	if len(containers) == 0 {
+		if len(replacements) == 0 {
+			return
+		}
+		container := doc.newElement(containerName)
+		doc.Root.replaceChildren(func(*modsNode) bool { return false }, []*modsNode{container}, 1)
+		containers = append(containers, container)
+	}
+
+	grouped := make([][]*modsNode, len(containers))
+	for idx, r := range replacements {
+		tgt := containerIdx[idx]
+		if tgt < 0 || tgt >= len(containers) {
+			tgt = 0
+		}
+		grouped[tgt] = append(grouped[tgt], r)
+	}
+	match := func(n *modsNode) bool { return matchesName(n, childNames) }
+	for idx, container := range containers {
+		container.replaceChildren(match, grouped[idx], 2)
+	}
+}
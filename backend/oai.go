@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OAI-PMH 2.0 provider for published metadata. Only records with a DL ingest date are exposed;
+// the datestamp of a record is the last DL update, falling back to the ingest date.
+
+const oaiDatestamp = "coalesce(date_dl_update, date_dl_ingest)"
+const oaiTimeFormat = "2006-01-02T15:04:05Z"
+const oaiDayFormat = "2006-01-02"
+const oaiIdentifierPageSize = 100
+const oaiRecordPageSize = 25
+
+type oaiConfig struct {
+	BaseURL      string
+	AdminEmail   string
+	RepositoryID string
+}
+
+func newOAIConfig(baseURL, email string) oaiConfig {
+	cfg := oaiConfig{BaseURL: baseURL, AdminEmail: email, RepositoryID: "tracksys.lib.virginia.edu"}
+	if parsed, err := url.Parse(baseURL); err == nil && parsed.Hostname() != "" {
+		cfg.RepositoryID = parsed.Hostname()
+	}
+	return cfg
+}
+
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+var oaiFormats = []oaiMetadataFormat{
+	{Prefix: "oai_dc", Schema: "http://www.openarchives.org/OAI/2.0/oai_dc.xsd", Namespace: "http://www.openarchives.org/OAI/2.0/oai_dc/"},
+	{Prefix: "mods", Schema: "http://www.loc.gov/standards/mods/v3/mods-3-7.xsd", Namespace: "http://www.loc.gov/mods/v3"},
+}
+
+// static sets for each publication destination; collection and facet sets are added from the DB
+var oaiDestinationSets = []struct {
+	Spec  string
+	Name  string
+	Query string
+}{
+	{Spec: "virgo", Name: "Published to Virgo", Query: publishedVirgoQuery},
+	{Spec: "dpla", Name: "Published to DPLA", Query: publishedDPLAQuery},
+	{Spec: "archivesspace", Name: "Published to ArchivesSpace", Query: publishedArchivesSpaceQuery},
+}
+
+// oaiParentSets are the parents of the collection:<id> and facet:<id> sets; ':' is the OAI-PMH hierarchy separator
+var oaiParentSets = []struct {
+	Spec  string
+	Name  string
+	Query string
+}{
+	{Spec: "collection", Name: "Collections", Query: "parent_metadata_id > 0"},
+	{Spec: "facet", Name: "Collection Facets", Query: "collection_facet in (select name from collection_facets)"},
+}
+
+type oaiRequestInfo struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiHeader struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiMetadata struct {
+	Content string `xml:",innerxml"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader   `xml:"header"`
+	Metadata oaiMetadata `xml:"metadata"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int64  `xml:"completeListSize,attr,omitempty"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiList struct {
+	Formats         []oaiMetadataFormat `xml:"metadataFormat,omitempty"`
+	Sets            []oaiSet            `xml:"set,omitempty"`
+	Headers         []oaiHeader         `xml:"header,omitempty"`
+	Records         []oaiRecord         `xml:"record,omitempty"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiResponse struct {
+	XMLName             xml.Name       `xml:"http://www.openarchives.org/OAI/2.0/ OAI-PMH"`
+	XmlnsXSI            string         `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string         `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string         `xml:"responseDate"`
+	Request             oaiRequestInfo `xml:"request"`
+	Errors              []oaiError     `xml:"error,omitempty"`
+	Identify            *oaiIdentify   `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiList       `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *oaiList       `xml:"ListSets,omitempty"`
+	ListIdentifiers     *oaiList       `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiList       `xml:"ListRecords,omitempty"`
+	GetRecord           *oaiList       `xml:"GetRecord,omitempty"`
+}
+
+// oaiListParams are the selective harvesting params of a list request. They are carried in the resumption token.
+type oaiListParams struct {
+	Prefix string
+	Set    string
+	From   string
+	Until  string
+	Offset int
+}
+
+var oaiVerbArgs = map[string][]string{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier"},
+	"ListSets":            {"resumptionToken"},
+	"ListIdentifiers":     {"metadataPrefix", "from", "until", "set", "resumptionToken"},
+	"ListRecords":         {"metadataPrefix", "from", "until", "set", "resumptionToken"},
+	"GetRecord":           {"identifier", "metadataPrefix"},
+}
+
+func (svc *serviceContext) oaiRequest(c *gin.Context) {
+	c.Request.ParseForm()
+	args := c.Request.Form
+	verb := args.Get("verb")
+	log.Printf("INFO: oai-pmh request %s", args.Encode())
+
+	out := oaiResponse{
+		XmlnsXSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiTimeFormat),
+		Request:        oaiRequestInfo{URL: svc.OAI.BaseURL},
+	}
+
+	allowed, ok := oaiVerbArgs[verb]
+	if ok == false {
+		out.Errors = append(out.Errors, oaiError{Code: "badVerb", Message: fmt.Sprintf("illegal or missing verb [%s]", verb)})
+		svc.sendOAIResponse(c, &out)
+		return
+	}
+	for name, vals := range args {
+		if name == "verb" {
+			continue
+		}
+		if len(vals) > 1 {
+			out.Errors = append(out.Errors, oaiError{Code: "badArgument", Message: fmt.Sprintf("repeated argument %s", name)})
+		} else if slices.Contains(allowed, name) == false {
+			out.Errors = append(out.Errors, oaiError{Code: "badArgument", Message: fmt.Sprintf("illegal argument %s", name)})
+		}
+	}
+	if len(args["verb"]) > 1 {
+		out.Errors = append(out.Errors, oaiError{Code: "badVerb", Message: "repeated verb"})
+	}
+	if args.Get("resumptionToken") != "" && len(args) > 2 {
+		out.Errors = append(out.Errors, oaiError{Code: "badArgument", Message: "resumptionToken is an exclusive argument"})
+	}
+	if len(out.Errors) > 0 {
+		svc.sendOAIResponse(c, &out)
+		return
+	}
+
+	out.Request = oaiRequestInfo{Verb: verb, Identifier: args.Get("identifier"), MetadataPrefix: args.Get("metadataPrefix"),
+		From: args.Get("from"), Until: args.Get("until"), Set: args.Get("set"), ResumptionToken: args.Get("resumptionToken"), URL: svc.OAI.BaseURL}
+
+	// oaiErr is an OAI-PMH protocol error reported in the response; err is an internal failure
+	var oaiErr *oaiError
+	var err error
+	switch verb {
+	case "Identify":
+		out.Identify, oaiErr = svc.oaiIdentify()
+	case "ListMetadataFormats":
+		out.ListMetadataFormats, oaiErr, err = svc.oaiListMetadataFormats(args.Get("identifier"))
+	case "ListSets":
+		out.ListSets, oaiErr, err = svc.oaiListSets(args.Get("resumptionToken"))
+	case "GetRecord":
+		out.GetRecord, oaiErr, err = svc.oaiGetRecord(args.Get("identifier"), args.Get("metadataPrefix"))
+	case "ListIdentifiers", "ListRecords":
+		params, paramErr := parseOAIListParams(args)
+		if paramErr != nil {
+			oaiErr = paramErr
+			break
+		}
+		var list *oaiList
+		list, oaiErr, err = svc.oaiList(params, verb == "ListRecords")
+		if verb == "ListRecords" {
+			out.ListRecords = list
+		} else {
+			out.ListIdentifiers = list
+		}
+	}
+	if err != nil {
+		log.Printf("ERROR: oai-pmh %s request failed: %s", verb, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if oaiErr != nil {
+		out.Errors = append(out.Errors, *oaiErr)
+	}
+	svc.sendOAIResponse(c, &out)
+}
+
+func (svc *serviceContext) sendOAIResponse(c *gin.Context, out *oaiResponse) {
+	if len(out.Errors) > 0 {
+		log.Printf("INFO: oai-pmh request failed: %+v", out.Errors)
+	}
+	raw, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		log.Printf("ERROR: unable to render oai-pmh response: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", append([]byte(xml.Header), raw...))
+}
+
+func (svc *serviceContext) oaiIdentify() (*oaiIdentify, *oaiError) {
+	var earliest time.Time
+	err := svc.DB.Table("metadata").Select("min(date_dl_ingest)").Where("date_dl_ingest is not null").Row().Scan(&earliest)
+	if err != nil {
+		log.Printf("ERROR: unable to get earliest oai datestamp: %s", err.Error())
+	}
+	return &oaiIdentify{
+		RepositoryName:    "University of Virginia Library Digital Collections",
+		BaseURL:           svc.OAI.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        svc.OAI.AdminEmail,
+		EarliestDatestamp: earliest.UTC().Format(oaiTimeFormat),
+		DeletedRecord:     "no",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}, nil
+}
+
+func (svc *serviceContext) oaiListMetadataFormats(identifier string) (*oaiList, *oaiError, error) {
+	if identifier != "" {
+		if _, oaiErr, err := svc.oaiLoadRecord(identifier); oaiErr != nil || err != nil {
+			return nil, oaiErr, err
+		}
+	}
+	return &oaiList{Formats: oaiFormats}, nil, nil
+}
+
+func (svc *serviceContext) oaiListSets(token string) (*oaiList, *oaiError, error) {
+	if token != "" {
+		return nil, &oaiError{Code: "badResumptionToken", Message: "ListSets does not use resumption tokens"}, nil
+	}
+	out := oaiList{}
+	for _, ds := range oaiDestinationSets {
+		out.Sets = append(out.Sets, oaiSet{Spec: ds.Spec, Name: ds.Name})
+	}
+	for _, ps := range oaiParentSets {
+		out.Sets = append(out.Sets, oaiSet{Spec: ps.Spec, Name: ps.Name})
+	}
+
+	var collections []metadata
+	err := svc.DB.Select("id", "title").Where("is_collection=? and date_dl_ingest is not null", true).Order("title asc").Find(&collections).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get collections for oai sets: %s", err.Error())
+	}
+	for _, coll := range collections {
+		out.Sets = append(out.Sets, oaiSet{Spec: fmt.Sprintf("collection:%d", coll.ID), Name: coll.Title})
+	}
+
+	var facets []collectionFacet
+	err = svc.DB.Order("name asc").Find(&facets).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get collection facets for oai sets: %s", err.Error())
+	}
+	for _, f := range facets {
+		out.Sets = append(out.Sets, oaiSet{Spec: fmt.Sprintf("facet:%d", f.ID), Name: f.Name})
+	}
+	return &out, nil, nil
+}
+
+func (svc *serviceContext) oaiGetRecord(identifier, prefix string) (*oaiList, *oaiError, error) {
+	if identifier == "" || prefix == "" {
+		return nil, &oaiError{Code: "badArgument", Message: "identifier and metadataPrefix are required"}, nil
+	}
+	if isOAIFormat(prefix) == false {
+		return nil, &oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("unsupported metadataPrefix %s", prefix)}, nil
+	}
+	md, oaiErr, err := svc.oaiLoadRecord(identifier)
+	if oaiErr != nil || err != nil {
+		return nil, oaiErr, err
+	}
+	facets := svc.oaiFacetIDs()
+	rec, err := svc.oaiBuildRecord(md, prefix, facets)
+	if err != nil {
+		log.Printf("ERROR: unable to build oai record for %s: %s", md.PID, err.Error())
+		return nil, &oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("unable to generate %s for %s", prefix, identifier)}, nil
+	}
+	return &oaiList{Records: []oaiRecord{*rec}}, nil, nil
+}
+
+// oaiList returns a page of headers or records. Records that cannot be built are logged and left out of the page.
+// Since the number of those is not known up front, completeListSize is only reported for ListIdentifiers.
+func (svc *serviceContext) oaiList(params *oaiListParams, withMetadata bool) (*oaiList, *oaiError, error) {
+	pageSize := oaiIdentifierPageSize
+	if withMetadata {
+		pageSize = oaiRecordPageSize
+	}
+	listQ, oaiErr := svc.oaiListQuery(params)
+	if oaiErr != nil {
+		return nil, oaiErr, nil
+	}
+
+	var total int64
+	err := listQ.Count(&total).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to count oai records: %s", err.Error())
+	}
+	if total == 0 {
+		return nil, &oaiError{Code: "noRecordsMatch", Message: "no records match the request"}, nil
+	}
+	if int64(params.Offset) >= total {
+		return nil, &oaiError{Code: "badResumptionToken", Message: "resumption token is past the end of the list"}, nil
+	}
+
+	var records []metadata
+	listQ, _ = svc.oaiListQuery(params)
+	if withMetadata {
+		listQ = listQ.Preload("AvailabilityPolicy").Preload("UseRight").Preload("ExternalSystem")
+	}
+	err = listQ.Order("id asc").Offset(params.Offset).Limit(pageSize).Find(&records).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get oai records: %s", err.Error())
+	}
+
+	out := oaiList{}
+	facets := svc.oaiFacetIDs()
+	for idx := range records {
+		md := &records[idx]
+		if withMetadata == false {
+			out.Headers = append(out.Headers, svc.oaiHeader(md, facets))
+			continue
+		}
+		rec, err := svc.oaiBuildRecord(md, params.Prefix, facets)
+		if err != nil {
+			// a single bad record should not break the harvest; it is skipped and logged
+			log.Printf("ERROR: unable to build oai record for %s; it is excluded from the list: %s", md.PID, err.Error())
+			continue
+		}
+		out.Records = append(out.Records, *rec)
+	}
+
+	nextOffset := params.Offset + len(records)
+	if params.Offset > 0 || int64(nextOffset) < total {
+		out.ResumptionToken = &oaiResumptionToken{Cursor: params.Offset}
+		if withMetadata == false {
+			out.ResumptionToken.CompleteListSize = total
+		}
+		if int64(nextOffset) < total {
+			next := *params
+			next.Offset = nextOffset
+			out.ResumptionToken.Token = next.encode()
+		}
+	}
+	return &out, nil, nil
+}
+
+func (svc *serviceContext) oaiListQuery(params *oaiListParams) (*gorm.DB, *oaiError) {
+	listQ := svc.DB.Model(&metadata{}).Where("date_dl_ingest is not null")
+	if params.From != "" {
+		from, _ := parseOAIDate(params.From, false)
+		listQ = listQ.Where(fmt.Sprintf("%s >= ?", oaiDatestamp), from)
+	}
+	if params.Until != "" {
+		until, _ := parseOAIDate(params.Until, true)
+		listQ = listQ.Where(fmt.Sprintf("%s <= ?", oaiDatestamp), until)
+	}
+	if params.Set == "" {
+		return listQ, nil
+	}
+
+	for _, ds := range oaiDestinationSets {
+		if ds.Spec == params.Set {
+			return listQ.Where(ds.Query), nil
+		}
+	}
+	for _, ps := range oaiParentSets {
+		if ps.Spec == params.Set {
+			return listQ.Where(ps.Query), nil
+		}
+	}
+	bits := strings.SplitN(params.Set, ":", 2)
+	if len(bits) == 2 {
+		setID, _ := strconv.ParseInt(bits[1], 10, 64)
+		switch bits[0] {
+		case "collection":
+			if setID > 0 {
+				return listQ.Where("parent_metadata_id=?", setID), nil
+			}
+		case "facet":
+			var facet collectionFacet
+			svc.DB.Limit(1).Find(&facet, setID)
+			if facet.ID > 0 {
+				return listQ.Where("collection_facet=?", facet.Name), nil
+			}
+		}
+	}
+	return nil, &oaiError{Code: "noRecordsMatch", Message: fmt.Sprintf("unknown set %s", params.Set)}
+}
+
+func (svc *serviceContext) oaiLoadRecord(identifier string) (*metadata, *oaiError, error) {
+	prefix := fmt.Sprintf("oai:%s:", svc.OAI.RepositoryID)
+	if strings.HasPrefix(identifier, prefix) == false {
+		return nil, &oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%s is not a valid identifier", identifier)}, nil
+	}
+	pid := strings.TrimPrefix(identifier, prefix)
+	var md metadata
+	err := svc.DB.Preload("AvailabilityPolicy").Preload("UseRight").Preload("ExternalSystem").Where("pid=? and date_dl_ingest is not null", pid).Limit(1).Find(&md).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load oai record %s: %s", pid, err.Error())
+	}
+	if md.ID == 0 {
+		return nil, &oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%s does not exist", identifier)}, nil
+	}
+	return &md, nil, nil
+}
+
+// oaiFacetIDs maps collection facet names to IDs for use in set specs
+func (svc *serviceContext) oaiFacetIDs() map[string]uint64 {
+	out := make(map[string]uint64)
+	var facets []collectionFacet
+	err := svc.DB.Find(&facets).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get collection facets: %s", err.Error())
+	}
+	for _, f := range facets {
+		out[f.Name] = f.ID
+	}
+	return out
+}
+
+func (svc *serviceContext) oaiHeader(md *metadata, facets map[string]uint64) oaiHeader {
+	hdr := oaiHeader{Identifier: fmt.Sprintf("oai:%s:%s", svc.OAI.RepositoryID, md.PID)}
+	stamp := md.DateDLIngest
+	if md.DateDLUpdate != nil {
+		stamp = md.DateDLUpdate
+	}
+	if stamp != nil {
+		hdr.Datestamp = stamp.UTC().Format(oaiTimeFormat)
+	}
+	if md.ExternalSystemID == nil {
+		hdr.SetSpecs = append(hdr.SetSpecs, "virgo")
+	} else if *md.ExternalSystemID == 1 {
+		hdr.SetSpecs = append(hdr.SetSpecs, "archivesspace")
+	}
+	if md.DPLA {
+		hdr.SetSpecs = append(hdr.SetSpecs, "dpla")
+	}
+	if md.ParentMetadataID > 0 {
+		hdr.SetSpecs = append(hdr.SetSpecs, fmt.Sprintf("collection:%d", md.ParentMetadataID))
+	}
+	if md.CollectionFacet != nil {
+		if facetID, ok := facets[*md.CollectionFacet]; ok {
+			hdr.SetSpecs = append(hdr.SetSpecs, fmt.Sprintf("facet:%d", facetID))
+		}
+	}
+	return hdr
+}
+
+func (svc *serviceContext) oaiBuildRecord(md *metadata, prefix string, facets map[string]uint64) (*oaiRecord, error) {
+	rec, err := svc.buildExportRecord(md)
+	if err != nil {
+		return nil, err
+	}
+	out := oaiRecord{Header: svc.oaiHeader(md, facets)}
+	if prefix == "mods" {
+		doc, err := rec.modsDocument()
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		doc.Root.write(&sb)
+		out.Metadata.Content = sb.String()
+	} else {
+		raw, err := xml.Marshal(rec.dublinCore())
+		if err != nil {
+			return nil, err
+		}
+		out.Metadata.Content = string(raw)
+	}
+	return &out, nil
+}
+
+func parseOAIListParams(args url.Values) (*oaiListParams, *oaiError) {
+	if token := args.Get("resumptionToken"); token != "" {
+		params, err := decodeOAIToken(token)
+		if err != nil {
+			return nil, &oaiError{Code: "badResumptionToken", Message: "invalid resumption token"}
+		}
+		return params, nil
+	}
+
+	params := oaiListParams{Prefix: args.Get("metadataPrefix"), Set: args.Get("set"), From: args.Get("from"), Until: args.Get("until")}
+	if params.Prefix == "" {
+		return nil, &oaiError{Code: "badArgument", Message: "metadataPrefix is required"}
+	}
+	if isOAIFormat(params.Prefix) == false {
+		return nil, &oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("unsupported metadataPrefix %s", params.Prefix)}
+	}
+	var from, until time.Time
+	var err error
+	if params.From != "" {
+		if from, err = parseOAIDate(params.From, false); err != nil {
+			return nil, &oaiError{Code: "badArgument", Message: fmt.Sprintf("invalid from date %s", params.From)}
+		}
+	}
+	if params.Until != "" {
+		if until, err = parseOAIDate(params.Until, true); err != nil {
+			return nil, &oaiError{Code: "badArgument", Message: fmt.Sprintf("invalid until date %s", params.Until)}
+		}
+	}
+	if params.From != "" && params.Until != "" {
+		if len(params.From) != len(params.Until) {
+			return nil, &oaiError{Code: "badArgument", Message: "from and until must have the same granularity"}
+		}
+		if until.Before(from) {
+			return nil, &oaiError{Code: "badArgument", Message: "from must be before until"}
+		}
+	}
+	return &params, nil
+}
+
+// parseOAIDate accepts day or seconds granularity. Day granularity until dates include the whole day.
+func parseOAIDate(val string, endOfDay bool) (time.Time, error) {
+	if len(val) == len(oaiDayFormat) {
+		day, err := time.Parse(oaiDayFormat, val)
+		if err == nil && endOfDay {
+			day = day.Add(24*time.Hour - time.Second)
+		}
+		return day, err
+	}
+	return time.Parse(oaiTimeFormat, val)
+}
+
+func isOAIFormat(prefix string) bool {
+	for _, f := range oaiFormats {
+		if f.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *oaiListParams) encode() string {
+	raw := strings.Join([]string{p.Prefix, p.Set, p.From, p.Until, fmt.Sprintf("%d", p.Offset)}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOAIToken(token string) (*oaiListParams, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	bits := strings.Split(string(raw), "|")
+	if len(bits) != 5 || isOAIFormat(bits[0]) == false {
+		return nil, fmt.Errorf("malformed token")
+	}
+	offset, err := strconv.Atoi(bits[4])
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("malformed offset")
+	}
+	return &oaiListParams{Prefix: bits[0], Set: bits[1], From: bits[2], Until: bits[3], Offset: offset}, nil
+}
@@ -12,19 +12,26 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// predicates for metadata records that have been published to each destination
+const (
+	publishedVirgoQuery         = "date_dl_ingest is not null and external_system_id is null"
+	publishedDPLAQuery          = "date_dl_ingest is not null and dpla=1"
+	publishedArchivesSpaceQuery = "date_dl_ingest is not null and external_system_id=1"
+)
+
 func (svc *serviceContext) getPublishedVirgo(c *gin.Context) {
 	log.Printf("INFO: get items published to virgo")
-	svc.getPublished(c, "date_dl_ingest is not null and external_system_id is null")
+	svc.getPublished(c, publishedVirgoQuery)
 }
 
 func (svc *serviceContext) getPublishedDPLA(c *gin.Context) {
 	log.Printf("INFO: get items published to dpla")
-	svc.getPublished(c, "date_dl_ingest is not null and dpla=1")
+	svc.getPublished(c, publishedDPLAQuery)
 }
 
 func (svc *serviceContext) getPublishedArchivesSpace(c *gin.Context) {
 	log.Printf("INFO: get items published to archivesspace")
-	svc.getPublished(c, "date_dl_ingest is not null and external_system_id=1")
+	svc.getPublished(c, publishedArchivesSpaceQuery)
 }
 
 // support pagination and filtering only
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// rightsDetermination is a suggested use right for a metadata record based on US copyright terms
+type rightsDetermination struct {
+	MetadataID       int64     `json:"metadataID"`
+	PID              string    `json:"pid"`
+	Title            string    `json:"title"`
+	PublicationYear  int       `json:"publicationYear,omitempty"`
+	CreatorDeathDate uint64    `json:"creatorDeathDate,omitempty"`
+	CorporateCreator bool      `json:"corporateCreator"`
+	Published        bool      `json:"published"`
+	Suggested        *useRight `json:"suggested"`
+	Assigned         *useRight `json:"assigned"`
+	Agrees           bool      `json:"agrees"`
+	Confidence       string    `json:"confidence"` // high, medium or low
+	Explanation      []string  `json:"explanation"`
+}
+
+// rights statement codes, matched against the use right URI
+const (
+	rightsNoCopyrightUS = "NoC-US"
+	rightsInCopyright   = "InC"
+	rightsUndetermined  = "UND"
+	rightsNotEvaluated  = "CNE"
+)
+
+var yearRegex = regexp.MustCompile(`\d{4}`)
+
+func (svc *serviceContext) getRightsDetermination(c *gin.Context) {
+	mdID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if mdID == 0 {
+		log.Printf("ERROR: invalid metadata id %s for rights determination", c.Param("id"))
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid id %s", c.Param("id")))
+		return
+	}
+	var md metadata
+	err := svc.DB.Preload("UseRight").Limit(1).Find(&md, mdID).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load metadata %d for rights determination: %s", mdID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if md.ID == 0 {
+		c.String(http.StatusNotFound, fmt.Sprintf("metadata %d not found", mdID))
+		return
+	}
+
+	var rights []useRight
+	err = svc.DB.Find(&rights).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load use rights: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("INFO: determine rights for metadata %d", mdID)
+	c.JSON(http.StatusOK, svc.determineRights(&md, rights))
+}
+
+// rightsReportBatchSize is the number of metadata records loaded at a time by the rights report job
+const rightsReportBatchSize = 500
+
+// rightsReport starts a job that lists records whose assigned use right differs from the suggested right
+func (svc *serviceContext) rightsReport(c *gin.Context) {
+	var req struct {
+		Type         string  `json:"type"`
+		CollectionID int64   `json:"collectionID"`
+		MetadataIDs  []int64 `json:"metadataIDs"`
+		Limit        int     `json:"limit"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid rights report request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mdQ := svc.DB.Model(&metadata{}).Where("type <> ?", "ExternalMetadata")
+	if req.Type != "" {
+		mdQ = mdQ.Where("type=?", req.Type)
+	}
+	if req.CollectionID > 0 {
+		mdQ = mdQ.Where("parent_metadata_id=?", req.CollectionID)
+	}
+	if len(req.MetadataIDs) > 0 {
+		mdQ = mdQ.Where("id in ?", req.MetadataIDs)
+	}
+	mdQ = mdQ.Session(&gorm.Session{})
+	var total int64
+	err = mdQ.Count(&total).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get metadata count for rights report: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if req.Limit > 0 && int64(req.Limit) < total {
+		total = int64(req.Limit)
+	}
+	if total == 0 {
+		c.String(http.StatusNotFound, "no metadata records match the request")
+		return
+	}
+
+	var rights []useRight
+	err = svc.DB.Find(&rights).Error
+	if err != nil {
+		log.Printf("ERROR: unable to load use rights: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	claims := getClaims(c)
+	js, err := svc.createJobStatus("RightsReport", "StaffMember", fmt.Sprintf("%d", claims.UserID))
+	if err != nil {
+		log.Printf("ERROR: unable to create rights report job: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// records are paged by id with only the columns used to determine rights so large reports stay small in memory
+	go func() {
+		svc.logJobEvent(js, 0, fmt.Sprintf("Check use rights for %d metadata records", total))
+		disagree := 0
+		checked := int64(0)
+		lastID := int64(0)
+		for checked < total {
+			var records []metadata
+			err := mdQ.Preload("UseRight").
+				Select("id", "pid", "title", "type", "barcode", "catalog_key", "creator_death_date", "desc_metadata",
+					"use_right_id", "is_manuscript", "is_personal_item").
+				Where("id > ?", lastID).Order("id asc").Limit(int(min(rightsReportBatchSize, total-checked))).Find(&records).Error
+			if err != nil {
+				svc.finishJobStatus(js, fmt.Sprintf("Unable to get metadata after id %d: %s", lastID, err.Error()))
+				return
+			}
+			if len(records) == 0 {
+				break
+			}
+			for idx := range records {
+				det := svc.determineRights(&records[idx], rights)
+				if records[idx].Type == "SirsiMetadata" {
+					// sirsi records may need a solr lookup; throttle them as the sirsi refresh job does
+					time.Sleep(50 * time.Millisecond)
+				}
+				if det.Agrees || det.Suggested == nil {
+					continue
+				}
+				disagree++
+				assigned := "none"
+				if det.Assigned != nil {
+					assigned = det.Assigned.Name
+				}
+				svc.logJobEvent(js, 1, fmt.Sprintf("%s assigned [%s], suggested [%s] (%s confidence): %s",
+					det.PID, assigned, det.Suggested.Name, det.Confidence, strings.Join(det.Explanation, " ")))
+			}
+			checked += int64(len(records))
+			lastID = records[len(records)-1].ID
+		}
+		svc.logJobEvent(js, 0, fmt.Sprintf("%d of %d records have a use right that differs from the suggestion", disagree, checked))
+		svc.finishJobStatus(js, "")
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"jobID": js.ID, "total": total})
+}
+
+func (svc *serviceContext) determineRights(md *metadata, rights []useRight) *rightsDetermination {
+	out := rightsDetermination{MetadataID: md.ID, PID: md.PID, Title: md.Title, Assigned: md.UseRight, Explanation: make([]string, 0)}
+	if md.CreatorDeathDate != nil {
+		out.CreatorDeathDate = *md.CreatorDeathDate
+	}
+
+	switch md.Type {
+	case "SirsiMetadata":
+		catKey := ""
+		if md.CatalogKey != nil {
+			catKey = *md.CatalogKey
+		}
+		barcode := ""
+		if md.Barcode != nil {
+			barcode = *md.Barcode
+		}
+		sirsiResp, _, err := svc.cachedSirsiLookup(catKey, barcode, false)
+		if err != nil {
+			out.Explanation = append(out.Explanation, fmt.Sprintf("Sirsi lookup failed: %s.", err.Error()))
+		} else {
+			out.PublicationYear = extractYear(sirsiResp.Year)
+			out.CorporateCreator = sirsiResp.CreatorType == "corporate"
+			// rights for sirsi records live in the ILS, not the metadata record
+			out.Assigned = findUseRight(rights, "", sirsiResp.UseRightName)
+		}
+	case "XmlMetadata":
+		if md.DescMetadata != nil {
+			if doc, err := parseMODSDocument(*md.DescMetadata); err == nil {
+				rec := exportRecord{}
+				rec.addMODS(doc)
+				out.PublicationYear = extractYear(rec.Date)
+				out.CorporateCreator = rec.CreatorType == "corporate"
+				// MODS dateIssued indicates a publication; other dates are creation dates. An undated
+				// dateIssued (n.d., 18--) gives no year to evaluate so the record is not treated as published.
+				out.Published = hasDateIssued(doc) && out.PublicationYear > 0
+			}
+		}
+	}
+	if md.Type == "SirsiMetadata" {
+		out.Published = out.PublicationYear > 0
+	}
+	if md.IsManuscript {
+		out.Published = false
+	}
+
+	code, confidence := out.evaluate(md, time.Now().Year())
+	out.Confidence = confidence
+	out.Suggested = findUseRight(rights, code, "")
+	if out.Suggested == nil {
+		out.Explanation = append(out.Explanation, fmt.Sprintf("No use right with code %s is defined.", code))
+	}
+	out.Agrees = out.Suggested != nil && out.Assigned != nil && out.Suggested.ID == out.Assigned.ID
+	return &out
+}
+
+// evaluate applies the US copyright term rules and returns the suggested rights statement code
+func (det *rightsDetermination) evaluate(md *metadata, currentYear int) (string, string) {
+	explain := func(msg string, args ...any) {
+		det.Explanation = append(det.Explanation, fmt.Sprintf(msg, args...))
+	}
+
+	if md.IsPersonalItem {
+		explain("Personal items belong to the patron and copyright is not evaluated by the library.")
+		return rightsNotEvaluated, "high"
+	}
+
+	publicDomainYear := currentYear - 95
+	if det.Published {
+		explain("Published in %d.", det.PublicationYear)
+		if det.PublicationYear < publicDomainYear {
+			explain("Works published in the US before %d are in the public domain.", publicDomainYear)
+			return rightsNoCopyrightUS, "high"
+		}
+		if det.PublicationYear < 1964 {
+			explain("Works published %d-1963 are in the public domain only if copyright was not renewed; renewal must be researched.", publicDomainYear)
+			return rightsUndetermined, "low"
+		}
+		if det.PublicationYear < 1978 {
+			explain("Works published 1964-1977 are protected for 95 years from publication, until %d.", det.PublicationYear+96)
+			return rightsInCopyright, "high"
+		}
+		if det.CreatorDeathDate > 0 && det.CorporateCreator == false {
+			if int(det.CreatorDeathDate) < currentYear-70 {
+				explain("Works published after 1977 are protected for the life of the author plus 70 years; the creator died in %d.", det.CreatorDeathDate)
+				return rightsNoCopyrightUS, "medium"
+			}
+			explain("Works published after 1977 are protected until %d, 70 years after the creator's death.", det.CreatorDeathDate+71)
+			return rightsInCopyright, "high"
+		}
+		explain("Works published after 1977 remain protected for at least 95 years from publication.")
+		return rightsInCopyright, "medium"
+	}
+
+	if md.IsManuscript {
+		explain("Manuscripts are unpublished works.")
+	} else {
+		explain("No publication date was found; treated as unpublished.")
+	}
+	if det.CreatorDeathDate > 0 {
+		if int(det.CreatorDeathDate) < currentYear-70 {
+			explain("Unpublished works are protected for the life of the author plus 70 years; the creator died in %d.", det.CreatorDeathDate)
+			return rightsNoCopyrightUS, "high"
+		}
+		explain("Unpublished works are protected until %d, 70 years after the creator's death.", det.CreatorDeathDate+71)
+		return rightsInCopyright, "high"
+	}
+	if det.PublicationYear > 0 && det.PublicationYear < currentYear-120 {
+		explain("Unpublished works with an unknown or corporate author created before %d are in the public domain.", currentYear-120)
+		return rightsNoCopyrightUS, "medium"
+	}
+	explain("The creator death date is unknown, so the copyright term cannot be determined.")
+	return rightsUndetermined, "low"
+}
+
+func hasDateIssued(doc *modsDocument) bool {
+	for _, oi := range doc.Root.elements("originInfo") {
+		if len(oi.elements("dateIssued")) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func extractYear(dateStr string) int {
+	match := yearRegex.FindString(dateStr)
+	if match == "" {
+		return 0
+	}
+	year, _ := strconv.Atoi(match)
+	return year
+}
+
+// findUseRight finds a use right by rights statement code (matched against the URI) or by name
+func findUseRight(rights []useRight, code, name string) *useRight {
+	for idx := range rights {
+		ur := &rights[idx]
+		if code != "" && strings.Contains(ur.URI, fmt.Sprintf("/%s/", code)) {
+			return ur
+		}
+		if name != "" && strings.EqualFold(ur.Name, name) {
+			return ur
+		}
+	}
+	return nil
+}
@@ -192,122 +192,6 @@ func (svc *serviceContext) deliverOrderMasterfiles(params any) error {
 // 	c.String(http.StatusOK, fmt.Sprintf("%d processed, %d missing", count, len(missing)))
 // }
 
-// SCRIPT TO ADD TO A COLLECTION BASED ON ARCHIVESSPACE URI
-// func (svc *serviceContext) scriptRunner(c *gin.Context) {
-// 	log.Printf("INFO: script runner called")
-
-// 	// //randolph_nicholas
-// 	// asCollectionID := 1395
-// 	// tsCollectionID := 108160
-
-// 	// randolph
-// 	asCollectionID := 1426
-// 	tsCollectionID := 108159
-
-// 	var memberURIs []string
-// 	memberSQL := "select external_uri from metadata where parent_metadata_id=?"
-// 	err := svc.DB.Raw(memberSQL, tsCollectionID).Scan(&memberURIs).Error
-// 	if err != nil {
-// 		log.Fatal(err.Error())
-// 		return
-// 	}
-// 	log.Printf("INFO: collection member uri list: %v", memberURIs)
-
-// 	bytes, reqErr := svc.getRequest(fmt.Sprintf("%s/archivesspace/collections/%d/records", svc.ExternalSystems.Jobs, asCollectionID))
-// 	if reqErr != nil {
-// 		log.Fatal(fmt.Sprintf("%d:%s", reqErr.StatusCode, reqErr.Message))
-// 	}
-
-// 	uris := make([]string, 0)
-// 	processedCount := 0
-// 	for _, extURI := range strings.Split(string(bytes), "\n") {
-// 		processedCount++
-// 		alreadyMember := false
-// 		for _, memberURI := range memberURIs {
-// 			if memberURI == extURI {
-// 				alreadyMember = true
-// 				break
-// 			}
-// 		}
-
-// 		if alreadyMember == false {
-// 			uris = append(uris, extURI)
-// 			log.Printf("INFO: %s added to the pending member list", extURI)
-// 			if len(uris) == 50 {
-// 				log.Printf("INFO: process chunk of %d items to add to collection", len(uris))
-// 				sql := "update metadata set parent_metadata_id=? where parent_metadata_id=? and external_system_id=? and external_uri in ?"
-// 				err := svc.DB.Exec(sql, tsCollectionID, 0, 1, uris).Error
-// 				if err != nil {
-// 					log.Fatal(err.Error())
-// 					break
-// 				}
-// 				uris = make([]string, 0)
-// 			}
-// 		} else {
-// 			log.Printf("INFO: %s is already part iof the colelction; skipping", extURI)
-// 		}
-// 	}
-
-// 	if len(uris) > 0 {
-// 		log.Printf("INFO: process final chunk of %d items to add to collection", len(uris))
-// 		sql := "update metadata set parent_metadata_id=? where parent_metadata_id=? and external_system_id=? and external_uri in ?"
-// 		err := svc.DB.Debug().Exec(sql, tsCollectionID, 0, 1, uris).Error
-// 		if err != nil {
-// 			log.Fatal(err.Error())
-// 		}
-// 	}
-
-// 	log.Printf("INFO: done. %d uris processed", processedCount)
-
-// 	c.String(http.StatusOK, fmt.Sprintf("%d uris processed", processedCount))
-// }
-
-// SAMPLE SCRIPT TO BULK ADD ITEMS TO A COLLECTION
-// func (svc *serviceContext) scriptRunner(c *gin.Context) {
-// 	log.Printf("INFO: script runner called")
-// 	bytes, err := os.ReadFile("./vickery_units.txt")
-// 	if err != nil {
-// 		log.Fatal(err.Error())
-// 	}
-
-// 	var collectionMD metadata
-// 	err = svc.DB.First(&collectionMD, 108065).Error
-// 	if err != nil {
-// 		log.Fatal("cannot find collection record: " + err.Error())
-// 	}
-
-// 	cnt := 0
-// 	for _, unitStr := range strings.Split(string(bytes), "\n") {
-// 		unitID, _ := strconv.ParseInt(unitStr, 10, 64)
-// 		if unitID == 0 {
-// 			continue
-// 		}
-// 		log.Printf("INFO: add %d to collection", unitID)
-// 		var tgtUnit unit
-// 		err = svc.DB.Preload("Metadata").First(&tgtUnit, unitID).Error
-// 		if err != nil {
-// 			log.Printf("ERROR: unable to get unit %d: %s", unitID, err.Error())
-// 			break
-// 		}
-
-// 		if tgtUnit.Metadata.ParentMetadataID != 0 {
-// 			log.Printf("WARNING: unit %d metadata %s already has parent metadata %d; skipping", unitID, tgtUnit.Metadata.PID, tgtUnit.Metadata.ParentMetadataID)
-// 			continue
-// 		}
-
-// 		tgtUnit.Metadata.ParentMetadataID = collectionMD.ID
-// 		err = svc.DB.Model(tgtUnit.Metadata).Update("parent_metadata_id", collectionMD.ID).Error
-// 		if err != nil {
-// 			log.Printf("ERROR: unable to update metadata %d parent: %s", tgtUnit.Metadata.ID, err.Error())
-// 			break
-// 		}
-
-// 		cnt++
-// 	}
-
-// 	c.String(http.StatusOK, fmt.Sprintf("%d records added to collection 108065", cnt))
-// }
-
 // SCRIPT TO FLAG ORDER METADATA FOR HATHITRUST PUBLISH
 // log.Printf("INFO: script runner called")
 // orderStr := c.Query("order")
@@ -322,40 +206,6 @@ func (svc *serviceContext) deliverOrderMasterfiles(params any) error {
 
 // c.String(http.StatusOK, fmt.Sprintf("flagged order %d", tgtID))
 
-// SAMPLE republish AS records
-// log.Printf("INFO: republish AS records from 4/17 and 4/18")
-// var asRecs []metadata
-// err := svc.DB.Where("type=? and external_system_id=? and date_dl_ingest like ?", "ExternalMetadata", 1, "2023-04-18%").Find(&asRecs).Error
-// if err != nil {
-// 	log.Printf("ERROR: unable to find recently published as records: %s", err.Error())
-// 	c.String(http.StatusInternalServerError, err.Error())
-// 	return
-// }
-
-// published := 0
-// errors := 0
-// for _, md := range asRecs {
-// 	log.Printf("INFO: publish %s to AS", md.PID)
-// 	payload := struct {
-// 		UserID     string `json:"userID"`
-// 		MetadataID string `json:"metadataID"`
-// 	}{
-// 		UserID:     "120", /// lf6f
-// 		MetadataID: fmt.Sprintf("%d", md.ID),
-// 	}
-// 	url := fmt.Sprintf("%s/archivesspace/publish?immediate=1", svc.ExternalSystems.Jobs)
-// 	asResp, asErr := svc.postJSON(url, payload)
-// 	if asErr != nil {
-// 		log.Printf("ERROR: AS publish %d failed %d: %s", md.ID, asErr.StatusCode, asErr.Message)
-// 		errors++
-// 	} else {
-// 		log.Printf("INFO: %s", asResp)
-// 		published++
-// 	}
-// }
-
-// c.String(http.StatusOK, fmt.Sprintf("DONE. %d total records, %d published, %d failed", len(asRecs), published, errors))
-
 // SAMPLE for updating AS date_dl_ingested
 //
 // log.Printf("INFO: update date_dl_ingest for published AS metadata records")
@@ -58,6 +58,10 @@ type serviceContext struct {
 	JWTKey          string
 	ExternalSystems externalSystems
 	DevAuthUser     string
+	OAI             oaiConfig
+	SirsiCache      *sirsiCache
+	ArchiveDir      string
+	HTPackageDir    string
 }
 
 // RequestError contains http status code and message for a failed HTTP request
@@ -102,8 +106,12 @@ func initializeService(version string, cfg *configData) *serviceContext {
 			Jobs:     cfg.jobsURL,
 			XMLIndex: cfg.xmlIndexURL,
 		},
-		JWTKey:      cfg.jwtKey,
-		DevAuthUser: cfg.devAuthUser}
+		JWTKey:       cfg.jwtKey,
+		DevAuthUser:  cfg.devAuthUser,
+		OAI:          newOAIConfig(cfg.oaiURL, cfg.oaiEmail),
+		SirsiCache:   newSirsiCache(cfg.sirsiCacheTTL, cfg.sirsiCacheDB, cfg.sirsiCacheMax),
+		ArchiveDir:   cfg.archiveDir,
+		HTPackageDir: cfg.htPackageDir}
 
 	log.Printf("INFO: connecting to DB...")
 	connectStr := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
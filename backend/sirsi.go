@@ -21,6 +21,8 @@ type subField struct {
 type dataField struct {
 	XMLName   xml.Name   `xml:"datafield"`
 	Tag       string     `xml:"tag,attr"`
+	Ind1      string     `xml:"ind1,attr"`
+	Ind2      string     `xml:"ind2,attr"`
 	Subfields []subField `xml:"subfield"`
 	Value     string     `xml:",chardata"`
 }
@@ -67,8 +69,10 @@ func (svc *serviceContext) lookupSirsiMetadata(c *gin.Context) {
 		c.String(http.StatusBadRequest, "barcode or ckey required")
 		return
 	}
-	resp, err := svc.doSirsiLookup(catKey, barcode)
+	resp, warning, err := svc.cachedSirsiLookup(catKey, barcode, c.Query("nocache") == "true")
 	if err != nil {
+		log.Printf("ERROR: sirsi lookup for catkey [%s] barcode [%s] failed: %s", catKey, barcode, err.Error())
+		c.String(http.StatusNotFound, err.Error())
 		return
 	}
 
@@ -77,8 +81,10 @@ func (svc *serviceContext) lookupSirsiMetadata(c *gin.Context) {
 		ExistingPID string `json:"existingPID"`
 		ExistingID  int64  `json:"existingID"`
 		Exists      bool   `json:"exists"`
+		Warning     string `json:"warning,omitempty"`
 	}{
 		sirsiResponse: resp,
+		Warning:       warning,
 	}
 
 	var existMD metadata
@@ -115,7 +121,19 @@ type solrResponse struct {
 	Response solrResponseDocuments `json:"response,omitempty"`
 }
 
+// doSirsiLookup always queries solr; successful results replace any cached lookup
 func (svc *serviceContext) doSirsiLookup(catKey, barcode string) (*sirsiResponse, error) {
+	parsed, err := svc.getSirsiMARC(catKey, barcode)
+	if err != nil {
+		return nil, err
+	}
+	resp := svc.parseSirsiMARC(parsed, catKey, barcode)
+	svc.SirsiCache.put(svc.DB, catKey, barcode, resp, &parsed.Record)
+	return resp, nil
+}
+
+// getSirsiMARC retrieves and parses the full MARC record for a catalog key or barcode from solr
+func (svc *serviceContext) getSirsiMARC(catKey, barcode string) (*marcMetadata, error) {
 	// prefer catkey over barcode
 	url := fmt.Sprintf("%s/select?fl=fullrecord&q=barcode_a:%s", svc.ExternalSystems.Solr, barcode)
 	if catKey != "" {
@@ -132,6 +150,9 @@ func (svc *serviceContext) doSirsiLookup(catKey, barcode string) (*sirsiResponse
 	if jErr != nil {
 		return nil, jErr
 	}
+	if len(solr.Response.Docs) == 0 {
+		return nil, fmt.Errorf("no matches found in sirsi")
+	}
 	rawMarc := []byte(solr.Response.Docs[0].FullRecord)
 
 	var parsed marcMetadata
@@ -143,7 +164,10 @@ func (svc *serviceContext) doSirsiLookup(catKey, barcode string) (*sirsiResponse
 	if len(parsed.Record.ControlFields) == 0 && len(parsed.Record.DataFields) == 0 {
 		return nil, fmt.Errorf("no matches found in sirsi")
 	}
+	return &parsed, nil
+}
 
+func (svc *serviceContext) parseSirsiMARC(parsed *marcMetadata, catKey, barcode string) *sirsiResponse {
 	log.Printf("INFO: extract fields from raw marc response")
 	resp := sirsiResponse{CatalogKey: catKey}
 
@@ -273,5 +297,5 @@ func (svc *serviceContext) doSirsiLookup(catKey, barcode string) (*sirsiResponse
 		}
 	}
 
-	return &resp, nil
+	return &resp
 }
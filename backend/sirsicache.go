@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sirsiCache holds parsed Solr/Sirsi lookups keyed by catalog key and barcode. Entries older than the TTL
+// are refreshed on the next lookup, but are kept so they can be served when Solr is unavailable. At most
+// maxSize entries are held in memory; the least recently used entry is dropped to make room for a new one.
+type sirsiCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	persist bool
+	maxSize int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type sirsiCacheEntry struct {
+	CacheKey   string         `gorm:"primaryKey" json:"cacheKey"`
+	CatalogKey string         `json:"catalogKey"`
+	Barcode    string         `json:"barcode"`
+	Response   *sirsiResponse `gorm:"serializer:json" json:"response"`
+	MARCRecord *marcRecord    `gorm:"serializer:json" json:"-"`
+	FetchedAt  time.Time      `json:"fetchedAt"`
+}
+
+func (sirsiCacheEntry) TableName() string {
+	return "sirsi_lookup_cache"
+}
+
+func newSirsiCache(ttl time.Duration, persist bool, maxSize int) *sirsiCache {
+	return &sirsiCache{ttl: ttl, persist: persist, maxSize: max(1, maxSize), entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func sirsiCacheKey(catKey, barcode string) string {
+	return fmt.Sprintf("%s|%s", strings.ToLower(strings.TrimSpace(catKey)), strings.ToUpper(strings.TrimSpace(barcode)))
+}
+
+func (sc *sirsiCache) get(db *gorm.DB, catKey, barcode string) *sirsiCacheEntry {
+	key := sirsiCacheKey(catKey, barcode)
+	sc.mutex.Lock()
+	var entry *sirsiCacheEntry
+	if el := sc.entries[key]; el != nil {
+		sc.lru.MoveToFront(el)
+		entry = el.Value.(*sirsiCacheEntry)
+	}
+	sc.mutex.Unlock()
+	if entry != nil || sc.persist == false {
+		return entry
+	}
+
+	var dbEntry sirsiCacheEntry
+	err := db.Where("cache_key=?", key).Limit(1).Find(&dbEntry).Error
+	if err != nil {
+		log.Printf("ERROR: unable to read sirsi cache entry %s: %s", key, err.Error())
+		return nil
+	}
+	if dbEntry.Response == nil {
+		return nil
+	}
+	sc.add(&dbEntry)
+	return &dbEntry
+}
+
+func (sc *sirsiCache) put(db *gorm.DB, catKey, barcode string, resp *sirsiResponse, record *marcRecord) {
+	entry := sirsiCacheEntry{CacheKey: sirsiCacheKey(catKey, barcode), CatalogKey: resp.CatalogKey, Barcode: resp.Barcode,
+		Response: resp, MARCRecord: record, FetchedAt: time.Now()}
+	sc.add(&entry)
+	if sc.persist {
+		err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error
+		if err != nil {
+			log.Printf("ERROR: unable to persist sirsi cache entry %s: %s", entry.CacheKey, err.Error())
+		}
+	}
+}
+
+// add stores an entry in memory as the most recently used, evicting the least recently used entry when full
+func (sc *sirsiCache) add(entry *sirsiCacheEntry) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if el := sc.entries[entry.CacheKey]; el != nil {
+		el.Value = entry
+		sc.lru.MoveToFront(el)
+		return
+	}
+	sc.entries[entry.CacheKey] = sc.lru.PushFront(entry)
+	for sc.lru.Len() > sc.maxSize {
+		oldest := sc.lru.Back()
+		sc.lru.Remove(oldest)
+		delete(sc.entries, oldest.Value.(*sirsiCacheEntry).CacheKey)
+	}
+}
+
+// invalidate removes entries matching the catalog key or barcode. If both are blank, everything is removed.
+func (sc *sirsiCache) invalidate(db *gorm.DB, catKey, barcode string) int {
+	catKey = strings.ToLower(strings.TrimSpace(catKey))
+	barcode = strings.ToUpper(strings.TrimSpace(barcode))
+	cnt := 0
+	sc.mutex.Lock()
+	for key, el := range sc.entries {
+		entry := el.Value.(*sirsiCacheEntry)
+		keyBits := strings.Split(key, "|")
+		matchCK := catKey != "" && (keyBits[0] == catKey || strings.ToLower(entry.CatalogKey) == catKey)
+		matchBC := barcode != "" && (keyBits[1] == barcode || strings.ToUpper(entry.Barcode) == barcode)
+		if (catKey == "" && barcode == "") || matchCK || matchBC {
+			sc.lru.Remove(el)
+			delete(sc.entries, key)
+			cnt++
+		}
+	}
+	sc.mutex.Unlock()
+
+	if sc.persist {
+		delQ := db.Where("1=1")
+		if catKey != "" && barcode != "" {
+			delQ = db.Where("catalog_key=? or barcode=? or cache_key like ? or cache_key like ?", catKey, barcode, catKey+"|%", "%|"+barcode)
+		} else if catKey != "" {
+			delQ = db.Where("catalog_key=? or cache_key like ?", catKey, catKey+"|%")
+		} else if barcode != "" {
+			delQ = db.Where("barcode=? or cache_key like ?", barcode, "%|"+barcode)
+		}
+		resp := delQ.Delete(&sirsiCacheEntry{})
+		if resp.Error != nil {
+			log.Printf("ERROR: unable to remove persisted sirsi cache entries: %s", resp.Error.Error())
+		} else if int(resp.RowsAffected) > cnt {
+			cnt = int(resp.RowsAffected)
+		}
+	}
+	return cnt
+}
+
+// cachedSirsiLookup returns a cached sirsi lookup if it is still fresh, otherwise it queries Solr. If Solr
+// fails and an expired entry exists, that entry is returned along with a warning describing its age.
+func (svc *serviceContext) cachedSirsiLookup(catKey, barcode string, bypass bool) (*sirsiResponse, string, error) {
+	entry := svc.SirsiCache.get(svc.DB, catKey, barcode)
+	if bypass == false && entry != nil && time.Since(entry.FetchedAt) < svc.SirsiCache.ttl {
+		return entry.Response, "", nil
+	}
+
+	resp, err := svc.doSirsiLookup(catKey, barcode)
+	if err != nil {
+		if entry != nil {
+			log.Printf("WARNING: sirsi lookup for [%s] [%s] failed; using cached data from %s: %s", catKey, barcode, entry.FetchedAt.Format(time.RFC3339), err.Error())
+			warning := fmt.Sprintf("Sirsi is unavailable; showing data cached on %s", entry.FetchedAt.Format("2006-01-02 15:04"))
+			return entry.Response, warning, nil
+		}
+		return nil, "", err
+	}
+	return resp, "", nil
+}
+
+// cachedSirsiMARC returns the MARC record and parsed lookup for a catalog key or barcode, querying Solr only when
+// there is no fresh cached entry that includes the MARC record
+func (svc *serviceContext) cachedSirsiMARC(catKey, barcode string) (*marcRecord, *sirsiResponse, error) {
+	entry := svc.SirsiCache.get(svc.DB, catKey, barcode)
+	if entry != nil && entry.MARCRecord != nil && time.Since(entry.FetchedAt) < svc.SirsiCache.ttl {
+		return entry.MARCRecord, entry.Response, nil
+	}
+	parsed, err := svc.getSirsiMARC(catKey, barcode)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := svc.parseSirsiMARC(parsed, catKey, barcode)
+	svc.SirsiCache.put(svc.DB, catKey, barcode, resp, &parsed.Record)
+	return &parsed.Record, resp, nil
+}
+
+func (svc *serviceContext) invalidateSirsiCache(c *gin.Context) {
+	catKey := c.Query("ckey")
+	barcode := c.Query("barcode")
+	log.Printf("INFO: invalidate sirsi cache for catkey [%s] barcode [%s]", catKey, barcode)
+	cnt := svc.SirsiCache.invalidate(svc.DB, catKey, barcode)
+	c.JSON(http.StatusOK, gin.H{"removed": cnt})
+}
+
+func (svc *serviceContext) getSirsiCacheEntry(c *gin.Context) {
+	catKey := c.Query("ckey")
+	barcode := c.Query("barcode")
+	entry := svc.SirsiCache.get(svc.DB, catKey, barcode)
+	if entry == nil {
+		c.String(http.StatusNotFound, fmt.Sprintf("no cached lookup for catkey [%s] barcode [%s]", catKey, barcode))
+		return
+	}
+	out := struct {
+		*sirsiCacheEntry
+		Expired bool `json:"expired"`
+	}{
+		sirsiCacheEntry: entry,
+		Expired:         time.Since(entry.FetchedAt) >= svc.SirsiCache.ttl,
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sirsiRefreshRequest struct {
+	Apply         bool    `json:"apply"`         // false only reports the differences
+	MetadataIDs   []int64 `json:"metadataIDs"`   // optional list of records to refresh
+	CollectionID  int64   `json:"collectionID"`  // optional; refresh only members of this collection
+	UpdatedBefore string  `json:"updatedBefore"` // optional; only records not updated since this date (YYYY-MM-DD)
+	Limit         int     `json:"limit"`
+}
+
+type sirsiFieldDiff struct {
+	Field    string `json:"field"`
+	Stored   string `json:"stored"`
+	Upstream string `json:"upstream"`
+}
+
+// refreshSirsiMetadata starts a job that compares the stored title, call number and creator
+// of SirsiMetadata records with the current values from Solr, optionally applying any changes
+func (svc *serviceContext) refreshSirsiMetadata(c *gin.Context) {
+	var req sirsiRefreshRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		log.Printf("ERROR: invalid sirsi refresh request: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mdQ := svc.DB.Select("id", "pid", "title", "call_number", "creator_name", "catalog_key", "barcode").Where("type=?", "SirsiMetadata")
+	if len(req.MetadataIDs) > 0 {
+		mdQ = mdQ.Where("id in ?", req.MetadataIDs)
+	}
+	if req.CollectionID > 0 {
+		mdQ = mdQ.Where("parent_metadata_id=?", req.CollectionID)
+	}
+	if req.UpdatedBefore != "" {
+		if _, err := time.Parse("2006-01-02", req.UpdatedBefore); err != nil {
+			log.Printf("ERROR: invalid updatedBefore date %s in sirsi refresh request", req.UpdatedBefore)
+			c.String(http.StatusBadRequest, fmt.Sprintf("invalid updatedBefore date %s", req.UpdatedBefore))
+			return
+		}
+		mdQ = mdQ.Where("updated_at < ?", req.UpdatedBefore)
+	}
+	if req.Limit > 0 {
+		mdQ = mdQ.Limit(req.Limit)
+	}
+
+	var records []metadata
+	err = mdQ.Order("id asc").Find(&records).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get sirsi metadata for refresh: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(records) == 0 {
+		c.String(http.StatusNotFound, "no sirsi metadata records match the request")
+		return
+	}
+
+	claims := getClaims(c)
+	jobName := "SirsiRefreshReport"
+	if req.Apply {
+		jobName = "SirsiRefresh"
+	}
+	js, err := svc.createJobStatus(jobName, "StaffMember", fmt.Sprintf("%d", claims.UserID))
+	if err != nil {
+		log.Printf("ERROR: unable to create sirsi refresh job: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("INFO: %s started %s job %d for %d records", claims.ComputeID, jobName, js.ID, len(records))
+	go svc.doSirsiRefresh(js, records, req.Apply)
+
+	c.JSON(http.StatusOK, gin.H{"jobID": js.ID, "total": len(records)})
+}
+
+func (svc *serviceContext) doSirsiRefresh(js *jobStatus, records []metadata, apply bool) {
+	svc.logJobEvent(js, 0, fmt.Sprintf("Compare %d SirsiMetadata records with Solr; apply changes: %t", len(records), apply))
+	changed := 0
+	for idx := range records {
+		md := &records[idx]
+		catKey := ""
+		if md.CatalogKey != nil {
+			catKey = *md.CatalogKey
+		}
+		barcode := ""
+		if md.Barcode != nil {
+			barcode = *md.Barcode
+		}
+		if catKey == "" && barcode == "" {
+			svc.logJobEvent(js, 1, fmt.Sprintf("%s has no catalog key or barcode; skipping", md.PID))
+			continue
+		}
+
+		sirsiResp, err := svc.doSirsiLookup(catKey, barcode)
+		time.Sleep(50 * time.Millisecond)
+		if err != nil {
+			svc.logJobEvent(js, 2, fmt.Sprintf("%s lookup failed: %s", md.PID, err.Error()))
+			continue
+		}
+
+		diffs := diffSirsiFields(md, sirsiResp)
+		if len(diffs) == 0 {
+			continue
+		}
+		changed++
+		for _, d := range diffs {
+			svc.logJobEvent(js, 0, fmt.Sprintf("%s %s: [%s] => [%s]", md.PID, d.Field, d.Stored, d.Upstream))
+		}
+		if apply {
+			svc.applySirsiDiffs(js, md, diffs)
+		}
+	}
+
+	svc.logJobEvent(js, 0, fmt.Sprintf("%d of %d records differ from Solr", changed, len(records)))
+	svc.finishJobStatus(js, "")
+}
+
+func diffSirsiFields(md *metadata, resp *sirsiResponse) []sirsiFieldDiff {
+	out := make([]sirsiFieldDiff, 0)
+	check := func(field string, stored *string, upstream string) {
+		upstream = strings.TrimSpace(upstream)
+		storedVal := ""
+		if stored != nil {
+			storedVal = strings.TrimSpace(*stored)
+		}
+		// an empty upstream value is more likely a parse gap than a real change; keep what is stored
+		if upstream != "" && upstream != storedVal {
+			out = append(out, sirsiFieldDiff{Field: field, Stored: storedVal, Upstream: upstream})
+		}
+	}
+	check("title", &md.Title, resp.Title)
+	check("callNumber", md.CallNumber, resp.CallNumber)
+	check("creatorName", md.CreatorName, resp.CreatorName)
+	return out
+}
+
+func (svc *serviceContext) applySirsiDiffs(js *jobStatus, md *metadata, diffs []sirsiFieldDiff) {
+	fields := make([]string, 0)
+	checkProjects := false
+	for _, d := range diffs {
+		val := d.Upstream
+		switch d.Field {
+		case "title":
+			md.Title = val
+			fields = append(fields, "Title")
+			checkProjects = true
+		case "callNumber":
+			md.CallNumber = &val
+			fields = append(fields, "CallNumber")
+			checkProjects = true
+		case "creatorName":
+			md.CreatorName = &val
+			fields = append(fields, "CreatorName")
+		}
+	}
+
+	err := svc.DB.Model(md).Select(fields).Updates(md).Error
+	if err != nil {
+		svc.logJobEvent(js, 2, fmt.Sprintf("%s update failed: %s", md.PID, err.Error()))
+		return
+	}
+
+	if checkProjects {
+		jwt, err := svc.mintTemporaryJWT()
+		if err != nil {
+			svc.logJobEvent(js, 2, fmt.Sprintf("%s updated but projects were not: unable to create jwt: %s", md.PID, err.Error()))
+			return
+		}
+		callNumber := ""
+		if md.CallNumber != nil {
+			callNumber = *md.CallNumber
+		}
+		svc.updateMetadataRelatedProjects(md.ID, md.Title, callNumber, jwt)
+	}
+}
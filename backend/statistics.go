@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -394,3 +396,138 @@ func addDateConstraint(baseQ *gorm.DB, fieldName string, dateQStr string) {
 		baseQ.Where(fmt.Sprintf("%s <= ?", fieldName), bits[1])
 	}
 }
+
+type hathiTrustStatusCount struct {
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+type hathiTrustStuckItem struct {
+	StatusID   uint      `json:"statusID"`
+	MetadataID int64     `json:"metadataID"`
+	PID        string    `json:"pid"`
+	Title      string    `json:"title"`
+	Barcode    string    `json:"barcode"`
+	Since      time.Time `json:"since"`
+}
+
+type hathiTrustStuckStage struct {
+	Stage string                `json:"stage"`
+	Count int64                 `json:"count"`
+	Items []hathiTrustStuckItem `json:"items"`
+}
+
+type hathiTrustOrderProgress struct {
+	OrderID         int64   `json:"orderID"`
+	Total           int64   `json:"total"`
+	Finished        int64   `json:"finished"`
+	PercentComplete float64 `json:"percentComplete"`
+}
+
+// getHathiTrustStats reports hathitrust submission progress. Items are stuck in a stage when the date that
+// started the stage is more than days (default 30) ago and the date that ends it is not set. Each stuck stage
+// lists up to 100 of the oldest items.
+func (svc *serviceContext) getHathiTrustStats(c *gin.Context) {
+	days, _ := strconv.Atoi(c.Query("days"))
+	if days <= 0 {
+		days = 30
+	}
+	log.Printf("INFO: get hathitrust statistics; stuck after %d days", days)
+
+	var resp struct {
+		Total               int64                     `json:"total"`
+		Finished            int64                     `json:"finished"`
+		AverageDaysToFinish float64                   `json:"averageDaysToFinish"`
+		PackageStatus       []hathiTrustStatusCount   `json:"packageStatus"`
+		MetadataStatus      []hathiTrustStatusCount   `json:"metadataStatus"`
+		StuckDays           int                       `json:"stuckDays"`
+		Stuck               []hathiTrustStuckStage    `json:"stuck"`
+		Orders              []hathiTrustOrderProgress `json:"orders"`
+	}
+	resp.StuckDays = days
+
+	var totals struct {
+		Total    int64
+		Finished int64
+		AvgDays  *float64
+	}
+	err := svc.DB.Table("hathitrust_statuses").
+		Select("count(*) as total, count(finished_at) as finished, avg(timestampdiff(SECOND, requested_at, finished_at))/86400 as avg_days").
+		Scan(&totals).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get hathitrust totals: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp.Total = totals.Total
+	resp.Finished = totals.Finished
+	if totals.AvgDays != nil {
+		resp.AverageDaysToFinish = math.Round(*totals.AvgDays*10) / 10
+	}
+
+	for _, col := range []string{"package_status", "metadata_status"} {
+		var counts []hathiTrustStatusCount
+		err = svc.DB.Table("hathitrust_statuses").
+			Select(fmt.Sprintf("coalesce(%s, '') as status, count(*) as count", col)).
+			Group("status").Order("status asc").Scan(&counts).Error
+		if err != nil {
+			log.Printf("ERROR: unable to get hathitrust %s counts: %s", col, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if col == "package_status" {
+			resp.PackageStatus = counts
+		} else {
+			resp.MetadataStatus = counts
+		}
+	}
+
+	// each stage is defined by the column that starts it and the condition that is true while it is incomplete
+	stages := []struct {
+		name     string
+		startCol string
+		pending  string
+	}{
+		{"package creation", "requested_at", "package_created_at is null"},
+		{"package submission", "package_created_at", "package_submitted_at is null"},
+		{"package ingest", "package_submitted_at", "finished_at is null and coalesce(package_status, '') <> 'accepted'"},
+		{"metadata submission", "requested_at", "metadata_submitted_at is null"},
+		{"metadata ingest", "metadata_submitted_at", "finished_at is null and coalesce(metadata_status, '') <> 'accepted'"},
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	resp.Stuck = make([]hathiTrustStuckStage, 0)
+	for _, stage := range stages {
+		stageQ := svc.DB.Table("hathitrust_statuses h").Joins("inner join metadata m on m.id = h.metadata_id").
+			Where(fmt.Sprintf("h.%s < ? and h.%s", stage.startCol, stage.pending), cutoff).Session(&gorm.Session{})
+		out := hathiTrustStuckStage{Stage: stage.name, Items: make([]hathiTrustStuckItem, 0)}
+		err = stageQ.Count(&out.Count).Error
+		if err == nil && out.Count > 0 {
+			err = stageQ.Select(fmt.Sprintf("h.id as status_id, h.metadata_id, m.pid, m.title, coalesce(m.barcode, '') as barcode, h.%s as since", stage.startCol)).
+				Order("since asc").Limit(100).Scan(&out.Items).Error
+		}
+		if err != nil {
+			log.Printf("ERROR: unable to get hathitrust items stuck in %s: %s", stage.name, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp.Stuck = append(resp.Stuck, out)
+	}
+
+	err = svc.DB.Table("hathitrust_statuses h").
+		Joins("inner join units u on u.metadata_id = h.metadata_id").
+		Select("u.order_id, count(distinct h.id) as total, count(distinct case when h.finished_at is not null then h.id end) as finished").
+		Group("u.order_id").Order("u.order_id desc").Scan(&resp.Orders).Error
+	if err != nil {
+		log.Printf("ERROR: unable to get hathitrust order progress: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for idx := range resp.Orders {
+		op := &resp.Orders[idx]
+		if op.Total > 0 {
+			op.PercentComplete = math.Round(float64(op.Finished)/float64(op.Total)*1000) / 10
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
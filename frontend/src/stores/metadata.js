@@ -255,9 +255,9 @@ export const useMetadataStore = defineStore('metadata', {
             system.setError(e)
          })
       },
-      async requestArchivesSpaceReview(userID) {
+      async requestArchivesSpaceReview() {
          const system = useSystemStore()
-         return axios.post( `/api/metadata/${this.detail.id}/archivesspace?user=${userID}` ).then( (response) => {
+         return axios.post( `/api/metadata/${this.detail.id}/archivesspace` ).then( (response) => {
           this.archivesSpaceReview = response.data
          }).catch( e => {
             system.setError(e)